@@ -0,0 +1,128 @@
+package vconnpool
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader 客户端证书，文件变化后重新加载，不需要重新创建 ConnPool。
+// 设置 tls.Config.GetClientCertificate 为 CertReloader.GetClientCertificate 使用。
+type CertReloader struct {
+	CertFile string          // 证书文件
+	KeyFile  string          // 私钥文件
+	Interval time.Duration   // 检查文件变化的间隔，0为1分钟
+	Pool     *ConnPool       // 不为nil，证书更新后清空池的 TLS 会话缓存，并关闭池中使用旧证书（包括会话恢复）的空闲连接，握手中使用旧证书的连接不入池
+	OnReload func(err error) // 重新加载后调用，err 不为nil 表示加载失败，继续使用旧证书
+
+	cert    *tls.Certificate
+	modTime time.Time
+	mu      sync.RWMutex
+}
+
+// modified 证书和私钥文件的最后修改时间
+func (T *CertReloader) modified() (time.Time, error) {
+	var mod time.Time
+	for _, name := range []string{T.CertFile, T.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return mod, err
+		}
+		if fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	return mod, nil
+}
+
+// Load 加载证书，文件没有变化不重复加载
+//
+//	error   错误
+func (T *CertReloader) Load() error {
+	_, err := T.load()
+	return err
+}
+
+func (T *CertReloader) load() (bool, error) {
+	mod, err := T.modified()
+	if err != nil {
+		return false, err
+	}
+
+	T.mu.RLock()
+	loaded := T.cert != nil && mod.Equal(T.modTime)
+	T.mu.RUnlock()
+	if loaded {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(T.CertFile, T.KeyFile)
+	if err != nil {
+		return false, err
+	}
+
+	T.mu.Lock()
+	old := T.cert
+	T.cert = &cert
+	T.modTime = mod
+	T.mu.Unlock()
+
+	if old != nil && T.Pool != nil {
+		T.Pool.clientCertChanged(old)
+	}
+	return true, nil
+}
+
+// Run 定时检查文件变化并重新加载，直到 ctx 取消
+//
+//	ctx context.Context 上下文
+//	error               错误
+func (T *CertReloader) Run(ctx context.Context) error {
+	interval := T.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := T.load()
+			if T.OnReload != nil && (reloaded || err != nil) {
+				T.OnReload(err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Certificate 当前证书，没有加载则先加载
+//
+//	*tls.Certificate    证书
+//	error               错误
+func (T *CertReloader) Certificate() (*tls.Certificate, error) {
+	T.mu.RLock()
+	cert := T.cert
+	T.mu.RUnlock()
+	if cert != nil {
+		return cert, nil
+	}
+	if err := T.Load(); err != nil {
+		return nil, err
+	}
+	T.mu.RLock()
+	defer T.mu.RUnlock()
+	return T.cert, nil
+}
+
+// GetClientCertificate 用于 tls.Config.GetClientCertificate
+//
+//	cri *tls.CertificateRequestInfo 证书请求
+//	*tls.Certificate                证书
+//	error                           错误
+func (T *CertReloader) GetClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return T.Certificate()
+}
@@ -0,0 +1,235 @@
+package vconnpool
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+// writeCert 生成自签名证书并写入文件
+func writeCert(t *testing.T, dir string, serial int64, mod time.Time) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(certFile, mod, mod)
+	os.Chtimes(keyFile, mod, mod)
+	return
+}
+
+// 证书更新后，关闭使用旧证书的空闲连接，新连接使用新证书
+func Test_CertReloader_1(t *testing.T) {
+	as := assert.New(t, true)
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeCert(t, dir, 1, now)
+
+	// 服务端记录客户端证书序列号
+	var (
+		mu      sync.Mutex
+		serials []int64
+	)
+	l := tlsListen(t, &tls.Config{
+		Certificates: tlsTestCertificates(t),
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			cert, err := x509.ParseCertificate(raw[0])
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			serials = append(serials, cert.SerialNumber.Int64())
+			mu.Unlock()
+			return nil
+		},
+	})
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+	cr := &CertReloader{CertFile: certFile, KeyFile: keyFile, Pool: cp}
+	as.NotError(cr.Load())
+	config := &tls.Config{
+		InsecureSkipVerify:   true,
+		GetClientCertificate: cr.GetClientCertificate,
+	}
+
+	conn, err := cp.DialTLS(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	conn.Close()
+	as.Equal(cp.ConnNum(), 1)
+
+	// 文件没有变化，不重复加载
+	as.NotError(cr.Load())
+	time.Sleep(10 * time.Millisecond)
+	as.Equal(cp.ConnNum(), 1)
+
+	// 更新证书
+	writeCert(t, dir, 2, now.Add(time.Second))
+	as.NotError(cr.Load())
+	time.Sleep(10 * time.Millisecond)
+	as.Equal(cp.ConnNum(), 0)
+
+	conn, err = cp.DialTLS(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	as.False(conn.(Conn).IsReuseConn())
+	_, err = conn.Write([]byte("tls"))
+	as.NotError(err)
+	_, err = io.ReadFull(conn, make([]byte, 3))
+	as.NotError(err)
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	as.Equal(serials, []int64{1, 2})
+}
+
+// 启用会话缓存，证书更新后关闭会话恢复的空闲连接，新连接不使用旧证书的会话
+func Test_CertReloader_session(t *testing.T) {
+	as := assert.New(t, true)
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeCert(t, dir, 1, now)
+
+	var (
+		mu      sync.Mutex
+		serials []int64
+	)
+	l := tlsListen(t, &tls.Config{
+		Certificates: tlsTestCertificates(t),
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			cert, err := x509.ParseCertificate(raw[0])
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			serials = append(serials, cert.SerialNumber.Int64())
+			mu.Unlock()
+			return nil
+		},
+	})
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{IdeConn: 5, TLSSessionCache: 8}
+	defer cp.Close()
+	cr := &CertReloader{CertFile: certFile, KeyFile: keyFile, Pool: cp}
+	as.NotError(cr.Load())
+	config := &tls.Config{
+		InsecureSkipVerify:   true,
+		GetClientCertificate: cr.GetClientCertificate,
+	}
+
+	dial := func() net.Conn {
+		conn, err := cp.DialTLSContext(context.WithValue(context.Background(), PriorityContextKey, true), raddr.Network(), raddr.String(), config)
+		as.NotError(err)
+		_, err = conn.Write([]byte("tls"))
+		as.NotError(err)
+		_, err = io.ReadFull(conn, make([]byte, 3))
+		as.NotError(err)
+		return conn
+	}
+
+	// 第二条连接使用会话恢复，没有记录证书
+	conn1 := dial()
+	conn2 := dial()
+	as.False(conn1.(TLSConn).ConnectionState().DidResume)
+	as.True(conn2.(TLSConn).ConnectionState().DidResume)
+	conn1.Close()
+	conn2.Close()
+	as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 0)
+	as.Equal(cp.ConnNum(), 2)
+
+	// 其它来源的证书，不受证书更新影响
+	otherFile, otherKey := writeCert(t, t.TempDir(), 3, now)
+	otherCert, err := tls.LoadX509KeyPair(otherFile, otherKey)
+	as.NotError(err)
+	other := &tls.Config{
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &otherCert, nil
+		},
+	}
+	conn3, err := cp.DialTLS(raddr.Network(), raddr.String(), other)
+	as.NotError(err)
+	conn3.Close()
+	as.Equal(cp.ConnNum(), 3)
+
+	oldCert, err := cr.Certificate()
+	as.NotError(err)
+	writeCert(t, dir, 2, now.Add(time.Second))
+	as.NotError(cr.Load())
+	time.Sleep(10 * time.Millisecond)
+	as.Equal(cp.ConnNum(), 1)
+
+	conn := dial()
+	as.False(conn.(TLSConn).ConnectionState().DidResume)
+	conn.Close()
+
+	// 证书更新时还在握手的连接，使用旧证书，不入池
+	inflight := &tls.Config{
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return oldCert, nil
+		},
+	}
+	conn4, err := cp.DialTLS(raddr.Network(), raddr.String(), inflight)
+	as.NotError(err)
+	_, err = conn4.Write([]byte("tls"))
+	as.NotError(err)
+	_, err = io.ReadFull(conn4, make([]byte, 3))
+	as.NotError(err)
+	conn4.Close()
+	time.Sleep(10 * time.Millisecond)
+	as.Equal(cp.ConnNum(), 2)
+
+	conn3, err = cp.DialTLS(raddr.Network(), raddr.String(), other)
+	as.NotError(err)
+	as.True(conn3.(Conn).IsReuseConn())
+	conn3.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	as.Equal(serials, []int64{1, 3, 2, 1})
+}
@@ -0,0 +1,277 @@
+// poolbench 连接池压力测试，用于验证 ConnPool 的配置。
+// 未指定 -addr 时，启动本地回显服务器。
+//
+// 请求模式：
+//
+//	steady  每个并发连续请求
+//	think   每个并发请求后随机等待 0~2倍 -think 时间
+//	burst   所有并发每隔 -burst 时间同时请求一次
+//
+// 报告复用率、拨号速率、延迟百分位、协程数和文件描述符数。
+//
+// 用法：
+//
+//	poolbench -c 50 -d 10s -pattern think -think 20ms -ide-conn 20 -max-conn 40
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/456vv/vconnpool/v2"
+)
+
+var (
+	flagAddr       = flag.String("addr", "", "回显服务器地址，为空启动本地回显服务器")
+	flagNetwork    = flag.String("network", "tcp", "连接类型")
+	flagConcurrent = flag.Int("c", 10, "并发数")
+	flagDuration   = flag.Duration("d", 10*time.Second, "测试时长")
+	flagSize       = flag.Int("size", 64, "每个请求的字节数")
+	flagPattern    = flag.String("pattern", "steady", "请求模式：steady, think, burst")
+	flagThink      = flag.Duration("think", 10*time.Millisecond, "think 模式的平均等待时间")
+	flagBurst      = flag.Duration("burst", 100*time.Millisecond, "burst 模式的间隔时间")
+	flagMaxConn    = flag.Int("max-conn", 0, "最大连接数，0为无限制连接")
+	flagIdeConn    = flag.Int("ide-conn", 10, "空闲连接数，0为不支持连接入池")
+	flagIdeTimeout = flag.Duration("ide-timeout", 0, "空闲自动超时，0为不超时")
+)
+
+// echoServer 本地回显服务器
+func echoServer() (net.Listener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l, nil
+}
+
+// openFiles 当前进程打开的文件描述符数量，不支持的系统返回 -1
+func openFiles() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+type bench struct {
+	cp      *vconnpool.ConnPool
+	network string
+	addr    string
+	payload []byte
+
+	requests int64
+	errors   int64
+	dials    int64
+	reuses   int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+
+	maxGoroutine int64
+	maxFiles     int64
+}
+
+func (T *bench) do(ctx context.Context) {
+	start := time.Now()
+	conn, err := T.cp.DialContext(ctx, T.network, T.addr)
+	if err != nil {
+		atomic.AddInt64(&T.errors, 1)
+		return
+	}
+	c := conn.(vconnpool.Conn)
+	if c.IsReuseConn() {
+		atomic.AddInt64(&T.reuses, 1)
+	} else {
+		atomic.AddInt64(&T.dials, 1)
+	}
+
+	buf := make([]byte, len(T.payload))
+	if _, err = c.Write(T.payload); err == nil {
+		_, err = io.ReadFull(c, buf)
+	}
+	if err != nil {
+		c.Discard()
+		atomic.AddInt64(&T.errors, 1)
+	}
+	c.Close()
+	if err != nil {
+		return
+	}
+
+	d := time.Since(start)
+	atomic.AddInt64(&T.requests, 1)
+	T.mu.Lock()
+	T.latencies = append(T.latencies, d)
+	T.mu.Unlock()
+}
+
+func (T *bench) worker(ctx context.Context, pattern string, think time.Duration, burst <-chan struct{}) {
+	for ctx.Err() == nil {
+		switch pattern {
+		case "burst":
+			select {
+			case <-burst:
+			case <-ctx.Done():
+				return
+			}
+			T.do(ctx)
+		case "think":
+			T.do(ctx)
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(2*think) + 1))):
+			case <-ctx.Done():
+				return
+			}
+		default:
+			T.do(ctx)
+		}
+	}
+}
+
+// sample 记录协程数和文件描述符数的峰值
+func (T *bench) sample(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&T.maxGoroutine) {
+			atomic.StoreInt64(&T.maxGoroutine, n)
+		}
+		if n := int64(openFiles()); n > atomic.LoadInt64(&T.maxFiles) {
+			atomic.StoreInt64(&T.maxFiles, n)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func (T *bench) report(elapsed time.Duration) {
+	T.mu.Lock()
+	lat := T.latencies
+	T.mu.Unlock()
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+
+	var (
+		requests = atomic.LoadInt64(&T.requests)
+		dials    = atomic.LoadInt64(&T.dials)
+		reuses   = atomic.LoadInt64(&T.reuses)
+		reuse    float64
+	)
+	if dials+reuses > 0 {
+		reuse = float64(reuses) / float64(dials+reuses) * 100
+	}
+
+	fmt.Printf("requests:    %d (%.1f/s)\n", requests, float64(requests)/elapsed.Seconds())
+	fmt.Printf("errors:      %d\n", atomic.LoadInt64(&T.errors))
+	fmt.Printf("dials:       %d (%.1f/s)\n", dials, float64(dials)/elapsed.Seconds())
+	fmt.Printf("reuses:      %d (%.2f%%)\n", reuses, reuse)
+	fmt.Printf("latency:     p50=%v p90=%v p99=%v max=%v\n",
+		percentile(lat, 0.5), percentile(lat, 0.9), percentile(lat, 0.99), percentile(lat, 1))
+	fmt.Printf("conns:       %d (idle %d)\n", T.cp.ConnNum(), T.cp.ConnNumIde(T.network, T.addr))
+	fmt.Printf("goroutines:  %d (peak %d)\n", runtime.NumGoroutine(), atomic.LoadInt64(&T.maxGoroutine))
+	fmt.Printf("open files:  %d (peak %d)\n", openFiles(), atomic.LoadInt64(&T.maxFiles))
+}
+
+func main() {
+	flag.Parse()
+	switch *flagPattern {
+	case "steady", "think", "burst":
+	default:
+		log.Fatalf("poolbench: unknown pattern %s", *flagPattern)
+	}
+
+	addr := *flagAddr
+	if addr == "" {
+		l, err := echoServer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer l.Close()
+		addr = l.Addr().String()
+	}
+
+	b := &bench{
+		cp: &vconnpool.ConnPool{
+			IdeConn:    *flagIdeConn,
+			MaxConn:    *flagMaxConn,
+			IdeTimeout: *flagIdeTimeout,
+		},
+		network: *flagNetwork,
+		addr:    addr,
+		payload: make([]byte, *flagSize),
+	}
+	defer b.cp.Close()
+	rand.Read(b.payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *flagDuration)
+	defer cancel()
+	go b.sample(ctx)
+
+	burst := make(chan struct{})
+	if *flagPattern == "burst" {
+		go func() {
+			ticker := time.NewTicker(*flagBurst)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					for i := 0; i < *flagConcurrent; i++ {
+						select {
+						case burst <- struct{}{}:
+						case <-ctx.Done():
+							return
+						}
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	log.Printf("poolbench: %s %s, %d concurrent, %s, pattern %s", *flagNetwork, addr, *flagConcurrent, *flagDuration, *flagPattern)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < *flagConcurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.worker(ctx, *flagPattern, *flagThink, burst)
+		}()
+	}
+	wg.Wait()
+	b.report(time.Since(start))
+}
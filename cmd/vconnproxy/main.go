@@ -0,0 +1,298 @@
+// vconnproxy 复用后端连接的 TCP 反向代理。
+// 每个客户端连接通过 ConnPool.DialContext 转发到后端，在协议边界把后端连接收回池中。
+//
+// 模式：
+//
+//	oneshot  客户端断开后，后端连接废弃，不回收
+//	session  客户端断开后，后端应答已经结束（-drain 时间内后端没有数据），后端连接回收到池中，否则废弃
+//	line     按行请求/应答，每个请求读取一条后端连接，应答后回收
+//
+// 用法：
+//
+//	vconnproxy -listen :8080 -backend 127.0.0.1:6379 -mode line -ide-conn 10 -stats :8081
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/456vv/vconnpool/v2"
+)
+
+var (
+	flagListen     = flag.String("listen", ":8080", "本地监听地址")
+	flagNetwork    = flag.String("network", "tcp", "后端连接类型")
+	flagBackend    = flag.String("backend", "", "后端地址")
+	flagMode       = flag.String("mode", "session", "回收模式：oneshot, session, line")
+	flagMaxConn    = flag.Int("max-conn", 0, "最大连接数，0为无限制连接")
+	flagIdeConn    = flag.Int("ide-conn", 10, "空闲连接数，0为不支持连接入池")
+	flagIdeTimeout = flag.Duration("ide-timeout", time.Minute, "空闲自动超时，0为不超时")
+	flagStats      = flag.String("stats", "", "统计信息的 HTTP 地址，为空不启用")
+	flagDrain      = flag.Duration("drain", 100*time.Millisecond, "session 模式客户端断开后，等待后端应答结束的时间")
+)
+
+// stats 统计
+type stats struct {
+	Accepted int64 `json:"accepted"` // 接受的客户端连接
+	Active   int64 `json:"active"`   // 活动的客户端连接
+	Dials    int64 `json:"dials"`    // 新建的后端连接
+	Reuses   int64 `json:"reuses"`   // 复用的后端连接
+	Errors   int64 `json:"errors"`   // 后端错误
+}
+
+type proxy struct {
+	cp      *vconnpool.ConnPool
+	network string
+	backend string
+	mode    string
+	drain   time.Duration
+	stats   stats
+}
+
+// meter 记录写入的字节数和最后开始写入的时间（即读取到数据的时间）
+type meter struct {
+	w    io.Writer
+	n    int64
+	last int64
+}
+
+func (T *meter) Write(p []byte) (int, error) {
+	atomic.StoreInt64(&T.last, time.Now().UnixNano())
+	n, err := T.w.Write(p)
+	atomic.AddInt64(&T.n, int64(n))
+	return n, err
+}
+
+func (T *proxy) dial(ctx context.Context) (vconnpool.Conn, error) {
+	conn, err := T.cp.DialContext(ctx, T.network, T.backend)
+	if err != nil {
+		atomic.AddInt64(&T.stats.Errors, 1)
+		return nil, err
+	}
+	c := conn.(vconnpool.Conn)
+	if c.IsReuseConn() {
+		atomic.AddInt64(&T.stats.Reuses, 1)
+	} else {
+		atomic.AddInt64(&T.stats.Dials, 1)
+	}
+	return c, nil
+}
+
+func (T *proxy) serve(client net.Conn) {
+	defer client.Close()
+	atomic.AddInt64(&T.stats.Accepted, 1)
+	atomic.AddInt64(&T.stats.Active, 1)
+	defer atomic.AddInt64(&T.stats.Active, -1)
+
+	var err error
+	switch T.mode {
+	case "line":
+		err = T.serveLine(client)
+	default:
+		err = T.serveSession(client)
+	}
+	if err != nil && err != io.EOF {
+		log.Printf("vconnproxy: %s: %v", client.RemoteAddr(), err)
+	}
+}
+
+// serveSession 整个客户端会话使用一条后端连接
+func (T *proxy) serveSession(client net.Conn) error {
+	backend, err := T.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	if T.mode == "oneshot" {
+		backend.Discard()
+	}
+
+	var (
+		wg       sync.WaitGroup
+		backErr  error
+		request  = &meter{w: backend}
+		response = &meter{w: client}
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, backErr = io.Copy(response, backend)
+	}()
+
+	_, err = io.Copy(request, client)
+
+	// 客户端断开，等待后端应答结束后停止读取
+	closed := time.Now()
+	backend.SetReadDeadline(closed.Add(T.drain))
+	wg.Wait()
+	backend.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		backend.Discard()
+		return err
+	}
+	if ne, ok := backErr.(net.Error); backErr != nil && !(ok && ne.Timeout()) {
+		// 后端关闭或出错，或者客户端没有读完应答
+		backend.Discard()
+		atomic.AddInt64(&T.stats.Errors, 1)
+		return nil
+	}
+	if !atBoundary(request, response, closed) {
+		// 请求没有应答，或者应答还没有结束，下一个客户端不能使用
+		backend.Discard()
+	}
+	return nil
+}
+
+// atBoundary 后端连接是否在协议边界：没有请求，或者最后的请求已经有应答，并且客户端断开后后端没有再发送数据
+func atBoundary(request, response *meter, closed time.Time) bool {
+	if atomic.LoadInt64(&request.n) == 0 {
+		return atomic.LoadInt64(&response.n) == 0
+	}
+	last := atomic.LoadInt64(&response.last)
+	return last > atomic.LoadInt64(&request.last) && last < closed.UnixNano()
+}
+
+// serveLine 按行请求/应答，每个请求读取一条后端连接
+func (T *proxy) serveLine(client net.Conn) error {
+	br := bufio.NewReader(client)
+	for {
+		req, err := br.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		resp, err := T.roundTrip(req)
+		if err != nil {
+			return err
+		}
+		if _, err = client.Write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (T *proxy) roundTrip(req []byte) ([]byte, error) {
+	backend, err := T.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+
+	if _, err = backend.Write(req); err != nil {
+		backend.Discard()
+		atomic.AddInt64(&T.stats.Errors, 1)
+		return nil, err
+	}
+
+	// 逐字节读取应答，不能读取到应答之后的数据
+	var (
+		resp []byte
+		b    = make([]byte, 1)
+	)
+	for {
+		if _, err = backend.Read(b); err != nil {
+			backend.Discard()
+			atomic.AddInt64(&T.stats.Errors, 1)
+			return nil, err
+		}
+		resp = append(resp, b[0])
+		if b[0] == '\n' {
+			return resp, nil
+		}
+	}
+}
+
+func (T *proxy) serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		stats
+		Conns int `json:"conns"` // 后端连接数
+		Idle  int `json:"idle"`  // 后端空闲连接数
+	}{
+		stats: stats{
+			Accepted: atomic.LoadInt64(&T.stats.Accepted),
+			Active:   atomic.LoadInt64(&T.stats.Active),
+			Dials:    atomic.LoadInt64(&T.stats.Dials),
+			Reuses:   atomic.LoadInt64(&T.stats.Reuses),
+			Errors:   atomic.LoadInt64(&T.stats.Errors),
+		},
+		Conns: T.cp.ConnNum(),
+		Idle:  T.cp.ConnNumIde(T.network, T.backend),
+	})
+}
+
+// run 接受客户端连接，直到监听器关闭
+func (T *proxy) run(l net.Listener) error {
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// 暂时性的错误（如文件描述符用完），延迟重试
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Printf("vconnproxy: accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go T.serve(conn)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *flagBackend == "" {
+		flag.Usage()
+		return
+	}
+	switch *flagMode {
+	case "oneshot", "session", "line":
+	default:
+		log.Fatalf("vconnproxy: unknown mode %s", *flagMode)
+	}
+
+	p := &proxy{
+		cp: &vconnpool.ConnPool{
+			IdeConn:    *flagIdeConn,
+			MaxConn:    *flagMaxConn,
+			IdeTimeout: *flagIdeTimeout,
+		},
+		network: *flagNetwork,
+		backend: *flagBackend,
+		mode:    *flagMode,
+		drain:   *flagDrain,
+	}
+	defer p.cp.Close()
+
+	if *flagStats != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/stats", p.serveStats)
+		go func() {
+			log.Fatal(http.ListenAndServe(*flagStats, mux))
+		}()
+	}
+
+	l, err := net.Listen("tcp", *flagListen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+	log.Printf("vconnproxy: %s -> %s (%s)", l.Addr(), *flagBackend, *flagMode)
+
+	log.Fatal(p.run(l))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/456vv/vconnpool/v2"
+	"github.com/issue9/assert/v2"
+)
+
+func Test_atBoundary(t *testing.T) {
+	as := assert.New(t, true)
+
+	closed := time.Now()
+	at := func(d time.Duration) int64 {
+		return closed.Add(d).UnixNano()
+	}
+	tests := []struct {
+		name     string
+		request  meter
+		response meter
+		ok       bool
+	}{
+		{name: "no request", ok: true},
+		{name: "unsolicited response", response: meter{n: 4, last: at(-time.Second)}},
+		{name: "request without response", request: meter{n: 5, last: at(-time.Second)}},
+		{name: "response before request", request: meter{n: 5, last: at(-time.Second)}, response: meter{n: 5, last: at(-2 * time.Second)}},
+		{name: "response after close", request: meter{n: 5, last: at(-time.Second)}, response: meter{n: 5, last: at(time.Millisecond)}},
+		{name: "response", request: meter{n: 5, last: at(-time.Second)}, response: meter{n: 5, last: at(-time.Millisecond)}, ok: true},
+	}
+	for _, test := range tests {
+		as.Equal(atBoundary(&test.request, &test.response, closed), test.ok, test.name)
+	}
+}
+
+// lineBackend 按行应答的后端，slow 开头的请求延迟应答。返回地址和接受的连接数
+func lineBackend(t *testing.T, delay time.Duration) (string, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	accepted := new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(line, "slow") {
+						time.Sleep(delay)
+					}
+					if _, err = conn.Write([]byte(line)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+func startProxy(t *testing.T, backend, mode string) (*proxy, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{
+		cp:      &vconnpool.ConnPool{IdeConn: 5},
+		network: "tcp",
+		backend: backend,
+		mode:    mode,
+		drain:   20 * time.Millisecond,
+	}
+	t.Cleanup(func() {
+		l.Close()
+		p.cp.Close()
+	})
+	go p.run(l)
+	return p, l.Addr().String()
+}
+
+func waitFor(f func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// 会话结束在应答边界，后端连接被下一个客户端复用；应答没有结束，后端连接废弃
+func Test_proxy_session(t *testing.T) {
+	as := assert.New(t, true)
+
+	backend, accepted := lineBackend(t, 200*time.Millisecond)
+	p, addr := startProxy(t, backend, "session")
+	idle := func() int { return p.cp.ConnNumIde("tcp", backend) }
+
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", addr)
+		as.NotError(err)
+		_, err = client.Write([]byte("ping\n"))
+		as.NotError(err)
+		line, err := bufio.NewReader(client).ReadString('\n')
+		as.NotError(err).Equal(line, "ping\n")
+		client.Close()
+		as.True(waitFor(func() bool { return idle() == 1 }))
+	}
+	as.Equal(atomic.LoadInt32(accepted), int32(1))
+	as.Equal(atomic.LoadInt64(&p.stats.Reuses), int64(1))
+
+	// 客户端断开时后端还没有应答
+	client, err := net.Dial("tcp", addr)
+	as.NotError(err)
+	_, err = client.Write([]byte("slow\n"))
+	as.NotError(err)
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+	as.True(waitFor(func() bool { return atomic.LoadInt64(&p.stats.Active) == 0 }))
+	as.Equal(idle(), 0)
+
+	// 没有请求的会话，后端连接回收
+	client, err = net.Dial("tcp", addr)
+	as.NotError(err)
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+	as.True(waitFor(func() bool { return idle() == 1 }))
+	as.Equal(atomic.LoadInt32(accepted), int32(2))
+}
+
+// 每个请求读取一条后端连接，应答后回收
+func Test_proxy_line(t *testing.T) {
+	as := assert.New(t, true)
+
+	backend, accepted := lineBackend(t, 0)
+	p, addr := startProxy(t, backend, "line")
+
+	client, err := net.Dial("tcp", addr)
+	as.NotError(err)
+	defer client.Close()
+	br := bufio.NewReader(client)
+	for _, req := range []string{"a\n", "b\n", "c\n"} {
+		_, err = client.Write([]byte(req))
+		as.NotError(err)
+		line, err := br.ReadString('\n')
+		as.NotError(err).Equal(line, req)
+	}
+	as.Equal(atomic.LoadInt32(accepted), int32(1))
+	as.Equal(atomic.LoadInt64(&p.stats.Reuses), int64(2))
+	as.Equal(p.cp.ConnNumIde("tcp", backend), 1)
+}
@@ -0,0 +1,490 @@
+package vconnpool
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration 时间，JSON/YAML 和环境变量中使用字符串，如 "30s"、"1m30s"。JSON/YAML 中的数字为纳秒
+type Duration time.Duration
+
+// String 字符串，如 "30s"
+func (T Duration) String() string {
+	return time.Duration(T).String()
+}
+
+// MarshalJSON 编码为字符串
+func (T Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(T.String())
+}
+
+// UnmarshalJSON 解码字符串或数字（纳秒）
+func (T *Duration) UnmarshalJSON(b []byte) error {
+	if len(b) != 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		return T.parse(s)
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*T = Duration(n)
+	return nil
+}
+
+// MarshalYAML 编码为字符串
+func (T Duration) MarshalYAML() (interface{}, error) {
+	return T.String(), nil
+}
+
+// UnmarshalYAML 解码字符串或数字（纳秒）
+func (T *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Tag == "!!int" {
+		var n int64
+		if err := value.Decode(&n); err != nil {
+			return err
+		}
+		*T = Duration(n)
+		return nil
+	}
+	return T.parse(value.Value)
+}
+
+func (T *Duration) parse(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*T = Duration(d)
+	return nil
+}
+
+// SocketOptionsConfig TCP 连接选项的配置，见 SocketOptions
+type SocketOptionsConfig struct {
+	KeepAlive         Duration `json:"keep_alive,omitempty" yaml:"keep_alive,omitempty"`
+	KeepAliveInterval Duration `json:"keep_alive_interval,omitempty" yaml:"keep_alive_interval,omitempty"`
+	KeepAliveCount    int      `json:"keep_alive_count,omitempty" yaml:"keep_alive_count,omitempty"`
+	NoDelay           *bool    `json:"no_delay,omitempty" yaml:"no_delay,omitempty"`
+	Linger            *int     `json:"linger,omitempty" yaml:"linger,omitempty"`
+	ReadBuffer        int      `json:"read_buffer,omitempty" yaml:"read_buffer,omitempty"`
+	WriteBuffer       int      `json:"write_buffer,omitempty" yaml:"write_buffer,omitempty"`
+	UserTimeout       Duration `json:"user_timeout,omitempty" yaml:"user_timeout,omitempty"`
+}
+
+func (T *SocketOptionsConfig) build() *SocketOptions {
+	return &SocketOptions{
+		KeepAlive:         time.Duration(T.KeepAlive),
+		KeepAliveInterval: time.Duration(T.KeepAliveInterval),
+		KeepAliveCount:    T.KeepAliveCount,
+		NoDelay:           T.NoDelay,
+		Linger:            T.Linger,
+		ReadBuffer:        T.ReadBuffer,
+		WriteBuffer:       T.WriteBuffer,
+		UserTimeout:       time.Duration(T.UserTimeout),
+	}
+}
+
+// TLSFileConfig TLS 的配置，用于 ConnPool.TLSConfig
+type TLSFileConfig struct {
+	ServerName         string   `json:"server_name,omitempty" yaml:"server_name,omitempty"`                   // 服务器名称
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"` // 不验证服务器证书
+	CAFile             string   `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`                           // 根证书文件（PEM），为空使用系统根证书
+	CertFile           string   `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`                       // 客户端证书文件
+	KeyFile            string   `json:"key_file,omitempty" yaml:"key_file,omitempty"`                         // 客户端私钥文件
+	NextProtos         []string `json:"next_protos,omitempty" yaml:"next_protos,omitempty"`                   // ALPN
+	MinVersion         string   `json:"min_version,omitempty" yaml:"min_version,omitempty"`                   // 最低版本，如 "1.2"
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (T *TLSFileConfig) build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         T.ServerName,
+		InsecureSkipVerify: T.InsecureSkipVerify,
+		NextProtos:         T.NextProtos,
+	}
+	if T.MinVersion != "" {
+		v, ok := tlsVersions[T.MinVersion]
+		if !ok {
+			return nil, errorOption("TLS.MinVersion", "unknown version %q", T.MinVersion)
+		}
+		config.MinVersion = v
+	}
+	if T.CAFile != "" {
+		pem, err := os.ReadFile(T.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errorOption("TLS.CAFile", "no certificate in %s", T.CAFile)
+		}
+	}
+	if T.CertFile != "" || T.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(T.CertFile, T.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// HedgeDialConfig 对冲拨号的配置，见 HedgeDial
+type HedgeDialConfig struct {
+	Delay        Duration `json:"delay,omitempty" yaml:"delay,omitempty"`
+	MinDelay     Duration `json:"min_delay,omitempty" yaml:"min_delay,omitempty"`
+	Samples      int      `json:"samples,omitempty" yaml:"samples,omitempty"`
+	OtherBackend bool     `json:"other_backend,omitempty" yaml:"other_backend,omitempty"`
+}
+
+// SlowStartConfig 慢启动的配置，见 SlowStart
+type SlowStartConfig struct {
+	Window   Duration `json:"window,omitempty" yaml:"window,omitempty"`
+	MinRatio float64  `json:"min_ratio,omitempty" yaml:"min_ratio,omitempty"`
+	DialRate float64  `json:"dial_rate,omitempty" yaml:"dial_rate,omitempty"`
+}
+
+// OutlierDetectionConfig 异常后端剔除的配置，见 OutlierDetection
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int      `json:"consecutive_errors,omitempty" yaml:"consecutive_errors,omitempty"`
+	ErrorRate          float64  `json:"error_rate,omitempty" yaml:"error_rate,omitempty"`
+	MinRequests        int      `json:"min_requests,omitempty" yaml:"min_requests,omitempty"`
+	Interval           Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	BaseEjectionTime   Duration `json:"base_ejection_time,omitempty" yaml:"base_ejection_time,omitempty"`
+	MaxEjectionTime    Duration `json:"max_ejection_time,omitempty" yaml:"max_ejection_time,omitempty"`
+	MaxEjectionPercent int      `json:"max_ejection_percent,omitempty" yaml:"max_ejection_percent,omitempty"`
+}
+
+// ServiceConfig 服务的配置，见 SetService
+type ServiceConfig struct {
+	Backends []Backend `json:"backends" yaml:"backends"`                     // 后端
+	Balancer string    `json:"balancer,omitempty" yaml:"balancer,omitempty"` // 负载均衡：round_robin（默认）, weighted_random, least_active, power_of_two, consistent_hash
+	Replicas int       `json:"replicas,omitempty" yaml:"replicas,omitempty"` // consistent_hash 每个权重的虚拟节点数
+}
+
+func (T *ServiceConfig) balancer(name string) (Balancer, error) {
+	switch T.Balancer {
+	case "", "round_robin":
+		return RoundRobinBalancer(), nil
+	case "weighted_random":
+		return WeightedRandomBalancer(), nil
+	case "least_active":
+		return LeastActiveBalancer(), nil
+	case "power_of_two":
+		return PowerOfTwoBalancer(), nil
+	case "consistent_hash":
+		return ConsistentHashBalancer(T.Replicas), nil
+	}
+	return nil, errorOption("Services["+name+"].Balancer", "unknown balancer %q", T.Balancer)
+}
+
+// Config 连接池的配置，可以从 JSON/YAML 文件（LoadConfig）和环境变量（LoadEnv）读取，使用 Build 创建连接池。
+// 运行中修改限制使用 Limits 和 Reconfigure。
+// 按地址覆盖的只有 TCP 连接选项（AddrSocketOptions）和本地 IP（AddrLocalAddrs），key 为 address（如 127.0.0.1:80）；
+// 空闲连接数、最大连接数、超时等是整个连接池的设置，不支持按地址覆盖。
+type Config struct {
+	IdeConn             int                             `json:"ide_conn,omitempty" yaml:"ide_conn,omitempty"`                           // 空闲连接数，0为不支持连接入池
+	MaxConn             int                             `json:"max_conn,omitempty" yaml:"max_conn,omitempty"`                           // 最大连接数，0为无限制连接
+	IdeTimeout          Duration                        `json:"ide_timeout,omitempty" yaml:"ide_timeout,omitempty"`                     // 空闲自动超时，0为不超时
+	TLSHandshakeTimeout Duration                        `json:"tls_handshake_timeout,omitempty" yaml:"tls_handshake_timeout,omitempty"` // TLS 握手超时
+	TLSSessionCache     int                             `json:"tls_session_cache,omitempty" yaml:"tls_session_cache,omitempty"`         // 每个 key 的 TLS 会话缓存容量
+	TLS                 *TLSFileConfig                  `json:"tls,omitempty" yaml:"tls,omitempty"`                                     // DialTLSContext 的默认 TLS 配置
+	ReadTimeout         Duration                        `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty"`                   // 读出的连接每次读取的超时
+	WriteTimeout        Duration                        `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty"`                 // 读出的连接每次写入的超时
+	LeaseIdleTimeout    Duration                        `json:"lease_idle_timeout,omitempty" yaml:"lease_idle_timeout,omitempty"`       // 读出的连接没有读写超过该时间，强制关闭
+	MaxLease            Duration                        `json:"max_lease,omitempty" yaml:"max_lease,omitempty"`                         // 读出的连接最长使用时间
+	ProxyProtocol       int                             `json:"proxy_protocol,omitempty" yaml:"proxy_protocol,omitempty"`               // 新建连接发送 PROXY 协议头的版本
+	SocketOptions       *SocketOptionsConfig            `json:"socket_options,omitempty" yaml:"socket_options,omitempty"`               // TCP 连接选项
+	AddrSocketOptions   map[string]*SocketOptionsConfig `json:"addr_socket_options,omitempty" yaml:"addr_socket_options,omitempty"`     // 按地址覆盖 TCP 连接选项
+	LocalAddrs          []string                        `json:"local_addrs,omitempty" yaml:"local_addrs,omitempty"`                     // 拨号使用的本地 IP，多个轮流使用
+	AddrLocalAddrs      map[string][]string             `json:"addr_local_addrs,omitempty" yaml:"addr_local_addrs,omitempty"`           // 按地址覆盖拨号使用的本地 IP
+	HedgeDial           *HedgeDialConfig                `json:"hedge_dial,omitempty" yaml:"hedge_dial,omitempty"`                       // 对冲拨号
+	SlowStart           *SlowStartConfig                `json:"slow_start,omitempty" yaml:"slow_start,omitempty"`                       // 服务后端的慢启动
+	OutlierDetection    *OutlierDetectionConfig         `json:"outlier_detection,omitempty" yaml:"outlier_detection,omitempty"`         // 服务的异常后端剔除
+	Services            map[string]*ServiceConfig       `json:"services,omitempty" yaml:"services,omitempty"`                           // 服务
+}
+
+// LoadConfig 从文件读取配置，扩展名是 .yaml 或 .yml 使用 YAML 格式，否则使用 JSON 格式
+//
+//	name string     文件路径
+//	*Config         配置
+//	error           错误
+func LoadConfig(name string) (*Config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vconnpool: config %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// LoadEnv 从环境变量读取配置，覆盖已有的值。
+// 环境变量名称是 prefix 加 JSON 标签的大写，子配置用 _ 连接，如 VCONNPOOL_IDE_TIMEOUT=30s、VCONNPOOL_HEDGE_DIAL_DELAY=50ms。
+// 字符串列表使用逗号分隔，map 使用 JSON，如 VCONNPOOL_SERVICES={"svc":{"backends":[{"address":"10.0.0.1:80"}]}}
+//
+//	prefix string   前缀，如 VCONNPOOL
+//	error           错误
+func (T *Config) LoadEnv(prefix string) error {
+	_, err := loadEnv(reflect.ValueOf(T).Elem(), strings.ToUpper(prefix))
+	return err
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// loadEnv 读取结构体字段的环境变量，返回是否读取到
+func loadEnv(v reflect.Value, prefix string) (bool, error) {
+	found := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := strings.ToUpper(tag)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		fv := v.Field(i)
+
+		// 子配置
+		if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+			sub := fv
+			if fv.IsNil() {
+				sub = reflect.New(fv.Type().Elem())
+			}
+			ok, err := loadEnv(sub.Elem(), name)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				fv.Set(sub)
+				found = true
+			}
+			continue
+		}
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(fv, s); err != nil {
+			return false, fmt.Errorf("vconnpool: env %s: %v", name, err)
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// setEnvValue 设置字段的值
+func setEnvValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		e := reflect.New(v.Type().Elem())
+		if err := setEnvValue(e.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(e)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			var ss []string
+			for _, e := range strings.Split(s, ",") {
+				if e = strings.TrimSpace(e); e != "" {
+					ss = append(ss, e)
+				}
+			}
+			v.Set(reflect.ValueOf(ss))
+			return nil
+		}
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	case reflect.Map:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
+
+// localAddrPolicy 本地 IP 的策略，一个固定使用，多个轮流使用
+func localAddrPolicy(name string, ips []string) (LocalAddrPolicy, error) {
+	addrs := make([]net.Addr, 0, len(ips))
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errorOption(name, "invalid IP %q", s)
+		}
+		addrs = append(addrs, &net.TCPAddr{IP: ip})
+	}
+	if len(addrs) == 1 {
+		return FixedLocalAddr(addrs[0]), nil
+	}
+	return RoundRobinLocalAddr(addrs...), nil
+}
+
+// Options 配置转换为选项
+//
+//	[]Option    选项
+//	error       错误，配置无效
+func (T *Config) Options() ([]Option, error) {
+	opts := []Option{
+		WithIdeConn(T.IdeConn),
+		WithMaxConn(T.MaxConn),
+		WithIdeTimeout(time.Duration(T.IdeTimeout)),
+		WithTLSHandshakeTimeout(time.Duration(T.TLSHandshakeTimeout)),
+		WithTLSSessionCache(T.TLSSessionCache),
+		WithReadTimeout(time.Duration(T.ReadTimeout)),
+		WithWriteTimeout(time.Duration(T.WriteTimeout)),
+		WithLeaseIdleTimeout(time.Duration(T.LeaseIdleTimeout)),
+		WithMaxLease(time.Duration(T.MaxLease)),
+		WithProxyProtocol(T.ProxyProtocol),
+	}
+	if T.TLS != nil {
+		config, err := T.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTLSConfig(config))
+	}
+	if T.SocketOptions != nil {
+		opts = append(opts, WithSocketOptions(T.SocketOptions.build()))
+	}
+	for address, so := range T.AddrSocketOptions {
+		if so != nil {
+			opts = append(opts, WithAddrSocketOptions(address, so.build()))
+		}
+	}
+	if len(T.LocalAddrs) != 0 {
+		policy, err := localAddrPolicy("LocalAddrs", T.LocalAddrs)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithLocalAddr(policy))
+	}
+	for address, ips := range T.AddrLocalAddrs {
+		if len(ips) == 0 {
+			continue
+		}
+		policy, err := localAddrPolicy("AddrLocalAddrs["+address+"]", ips)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAddrLocalAddr(address, policy))
+	}
+	if hd := T.HedgeDial; hd != nil {
+		opts = append(opts, WithHedgeDial(&HedgeDial{
+			Delay:        time.Duration(hd.Delay),
+			MinDelay:     time.Duration(hd.MinDelay),
+			Samples:      hd.Samples,
+			OtherBackend: hd.OtherBackend,
+		}))
+	}
+	if ss := T.SlowStart; ss != nil {
+		opts = append(opts, WithSlowStart(&SlowStart{
+			Window:   time.Duration(ss.Window),
+			MinRatio: ss.MinRatio,
+			DialRate: ss.DialRate,
+		}))
+	}
+	if od := T.OutlierDetection; od != nil {
+		opts = append(opts, WithOutlierDetection(&OutlierDetection{
+			ConsecutiveErrors:  od.ConsecutiveErrors,
+			ErrorRate:          od.ErrorRate,
+			MinRequests:        od.MinRequests,
+			Interval:           time.Duration(od.Interval),
+			BaseEjectionTime:   time.Duration(od.BaseEjectionTime),
+			MaxEjectionTime:    time.Duration(od.MaxEjectionTime),
+			MaxEjectionPercent: od.MaxEjectionPercent,
+		}))
+	}
+	for name, svc := range T.Services {
+		if svc == nil {
+			continue
+		}
+		balancer, err := svc.balancer(name)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithService(name, svc.Backends, balancer))
+	}
+	return opts, nil
+}
+
+// Build 使用配置创建连接池，opts 在配置之后应用，可以设置 Dialer、回调函数等不能配置的选项
+//
+//	opts ...Option  选项
+//	*ConnPool       连接池
+//	error           错误，配置无效
+func (T *Config) Build(opts ...Option) (*ConnPool, error) {
+	copts, err := T.Options()
+	if err != nil {
+		return nil, err
+	}
+	return New(append(copts, opts...)...)
+}
+
+// Limits 配置中的连接池限制，用于 Reconfigure
+func (T *Config) Limits() Limits {
+	return Limits{IdeConn: T.IdeConn, MaxConn: T.MaxConn, IdeTimeout: time.Duration(T.IdeTimeout)}
+}
@@ -0,0 +1,164 @@
+package vconnpool
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+func Test_Duration(t *testing.T) {
+	as := assert.New(t, true)
+
+	var d Duration
+	as.NotError(json.Unmarshal([]byte(`"1m30s"`), &d))
+	as.Equal(d, Duration(90*time.Second))
+	as.NotError(json.Unmarshal([]byte(`1000`), &d))
+	as.Equal(d, Duration(time.Microsecond))
+	as.Error(json.Unmarshal([]byte(`"abc"`), &d))
+
+	b, err := json.Marshal(Duration(time.Second))
+	as.NotError(err)
+	as.Equal(string(b), `"1s"`)
+}
+
+func Test_LoadConfig(t *testing.T) {
+	as := assert.New(t, true)
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "pool.json")
+	as.NotError(os.WriteFile(jsonFile, []byte(`{
+		"ide_conn": 5,
+		"max_conn": 10,
+		"ide_timeout": "30s",
+		"socket_options": {"keep_alive": "15s", "no_delay": true},
+		"hedge_dial": {"delay": "50ms"},
+		"tls": {"server_name": "example.com", "min_version": "1.2"},
+		"services": {"svc": {"backends": [{"address": "127.0.0.1:80", "weight": 2}], "balancer": "least_active"}}
+	}`), 0o644))
+	c, err := LoadConfig(jsonFile)
+	as.NotError(err)
+	as.Equal(c.IdeConn, 5).Equal(c.MaxConn, 10)
+	as.Equal(c.IdeTimeout, Duration(30*time.Second))
+	as.Equal(c.SocketOptions.KeepAlive, Duration(15*time.Second))
+	as.True(*c.SocketOptions.NoDelay)
+	as.Equal(c.HedgeDial.Delay, Duration(50*time.Millisecond))
+	as.Equal(c.TLS.ServerName, "example.com")
+	as.Equal(c.Services["svc"].Backends, []Backend{{Address: "127.0.0.1:80", Weight: 2}})
+
+	yamlFile := filepath.Join(dir, "pool.yaml")
+	as.NotError(os.WriteFile(yamlFile, []byte(`
+ide_conn: 5
+ide_timeout: 1m
+lease_idle_timeout: 2000000000
+local_addrs: [127.0.0.1]
+services:
+  svc:
+    balancer: consistent_hash
+    replicas: 10
+    backends:
+      - address: 127.0.0.1:80
+      - address: 127.0.0.1:81
+        weight: 3
+`), 0o644))
+	c, err = LoadConfig(yamlFile)
+	as.NotError(err)
+	as.Equal(c.IdeConn, 5)
+	as.Equal(c.IdeTimeout, Duration(time.Minute))
+	as.Equal(c.LeaseIdleTimeout, Duration(2*time.Second))
+	as.Equal(c.LocalAddrs, []string{"127.0.0.1"})
+	as.Equal(c.Services["svc"].Replicas, 10)
+	as.Equal(c.Services["svc"].Backends[1], Backend{Address: "127.0.0.1:81", Weight: 3})
+
+	// 未知字段
+	as.NotError(os.WriteFile(jsonFile, []byte(`{"ide_con": 5}`), 0o644))
+	_, err = LoadConfig(jsonFile)
+	as.Error(err)
+	_, err = LoadConfig(filepath.Join(dir, "none.json"))
+	as.Error(err)
+}
+
+func Test_Config_LoadEnv(t *testing.T) {
+	as := assert.New(t, true)
+
+	env := map[string]string{
+		"VCONNPOOL_MAX_CONN":                  "20",
+		"VCONNPOOL_IDE_TIMEOUT":               "45s",
+		"VCONNPOOL_LOCAL_ADDRS":               "127.0.0.1, 127.0.0.2",
+		"VCONNPOOL_SOCKET_OPTIONS_NO_DELAY":   "false",
+		"VCONNPOOL_SOCKET_OPTIONS_KEEP_ALIVE": "10s",
+		"VCONNPOOL_SLOW_START_MIN_RATIO":      "0.25",
+		"VCONNPOOL_TLS_NEXT_PROTOS":           "h2,http/1.1",
+		"VCONNPOOL_SERVICES":                  `{"svc":{"backends":[{"address":"127.0.0.1:80"}]}}`,
+	}
+	// t.Setenv 需要 Go 1.17
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	c := &Config{IdeConn: 5, MaxConn: 10}
+	as.NotError(c.LoadEnv("vconnpool"))
+	as.Equal(c.IdeConn, 5).Equal(c.MaxConn, 20)
+	as.Equal(c.IdeTimeout, Duration(45*time.Second))
+	as.Equal(c.LocalAddrs, []string{"127.0.0.1", "127.0.0.2"})
+	as.False(*c.SocketOptions.NoDelay)
+	as.Equal(c.SocketOptions.KeepAlive, Duration(10*time.Second))
+	as.Equal(c.SlowStart.MinRatio, 0.25)
+	as.Equal(c.TLS.NextProtos, []string{"h2", "http/1.1"})
+	as.Equal(c.Services["svc"].Backends[0].Address, "127.0.0.1:80")
+	as.Nil(c.HedgeDial).Nil(c.OutlierDetection)
+
+	os.Setenv("VCONNPOOL_IDE_CONN", "abc")
+	defer os.Unsetenv("VCONNPOOL_IDE_CONN")
+	as.Error(c.LoadEnv("VCONNPOOL"))
+}
+
+func Test_Config_Build(t *testing.T) {
+	as := assert.New(t, true)
+
+	c := &Config{
+		IdeConn:         5,
+		MaxConn:         10,
+		IdeTimeout:      Duration(time.Minute),
+		MaxLease:        Duration(time.Hour),
+		SocketOptions:   &SocketOptionsConfig{KeepAlive: Duration(time.Second)},
+		AddrLocalAddrs:  map[string][]string{"127.0.0.1:80": {"127.0.0.1"}},
+		HedgeDial:       &HedgeDialConfig{Delay: Duration(10 * time.Millisecond)},
+		TLS:             &TLSFileConfig{ServerName: "example.com", MinVersion: "1.2"},
+		Services:        map[string]*ServiceConfig{"svc": {Backends: []Backend{{Address: "127.0.0.1:80"}}, Balancer: "power_of_two"}},
+		TLSSessionCache: 8,
+	}
+	cp, err := c.Build(WithIdeConn(3))
+	as.NotError(err)
+	defer cp.Close()
+	as.Equal(cp.IdeConn, 3).Equal(cp.MaxConn, 10)
+	as.Equal(cp.IdeTimeout, time.Minute).Equal(cp.MaxLease, time.Hour)
+	as.Equal(cp.TLSSessionCache, 8)
+	as.Equal(c.Limits(), Limits{IdeConn: 5, MaxConn: 10, IdeTimeout: time.Minute})
+	as.NotError(cp.Reconfigure(c.Limits()))
+	as.Equal(cp.ideConn(), 5)
+	as.Equal(cp.SocketOptions.KeepAlive, time.Second)
+	as.NotNil(cp.AddrLocalAddr["127.0.0.1:80"])
+	as.Equal(cp.HedgeDial.Delay, 10*time.Millisecond)
+	as.Equal(cp.TLSConfig.ServerName, "example.com")
+	as.Equal(cp.TLSConfig.MinVersion, uint16(tls.VersionTLS12))
+	as.Length(cp.ServiceBackends("tcp", "svc"), 1)
+
+	// 无效配置
+	for _, c := range []*Config{
+		{IdeConn: 5, MaxConn: 2},
+		{IdeTimeout: Duration(-time.Second)},
+		{LocalAddrs: []string{"abc"}},
+		{TLS: &TLSFileConfig{MinVersion: "2.0"}},
+		{TLS: &TLSFileConfig{CertFile: "none.pem", KeyFile: "none.key"}},
+		{Services: map[string]*ServiceConfig{"svc": {Balancer: "random"}}},
+	} {
+		_, err := c.Build()
+		as.Error(err)
+	}
+}
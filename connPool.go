@@ -2,6 +2,7 @@ package vconnpool
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +22,7 @@ var (
 
 	ErrConnNotAvailable = errors.New("vconnpool: no connections available in the pool")
 	ErrPoolFull         = errors.New("vconnpool: the number of idle connections has reached the maximum")
+	errorCertRetired    = errors.New("vconnpool: the client certificate of the connection has been replaced")
 )
 
 type atomicBool int32
@@ -45,15 +47,80 @@ type Conn interface {
 	RawConnFull([]byte) (net.Conn, int) // 原始连接，这个连接使用 Close 关闭后，不会回收
 }
 
+// TLSConn TLS 连接接口，DialTLSContext 读出的连接和 *tls.Conn 都实现了它，使用类型断言 conn.(TLSConn) 读取
+type TLSConn interface {
+	net.Conn                              // 连接
+	ConnectionState() tls.ConnectionState // TLS 连接状态，不是 TLS 连接 HandshakeComplete 为 false
+}
+
 // connSingle 单连接
 type connSingle struct {
-	net.Conn            // 连接
-	addr     net.Addr   // 地址用于回收识别
-	cp       *ConnPool  // 池
-	isPool   bool       // 连接来源，判断连接是不是从池里读出来的
-	closed   atomicBool // 连接关闭了
-	discard  atomicBool // 废弃（这条连接不再回收）
-	rawRead  atomicBool
+	active        int64       // 最后读写的时间，第一个字段保证 64 位原子操作对齐
+	net.Conn                  // 连接
+	key           string      // 池的 key，用于回收识别
+	backend       *backend    // 服务的后端，不是服务为nil
+	cp            *ConnPool   // 池
+	isPool        bool        // 连接来源，判断连接是不是从池里读出来的
+	closed        atomicBool  // 连接关闭了
+	discard       atomicBool  // 废弃（这条连接不再回收）
+	rawRead       atomicBool  // 已经读出原始连接
+	expired       atomicBool  // 租用超时关闭了
+	readDeadline  atomicBool  // 使用者设置了读取超时，不再使用默认的读取超时
+	writeDeadline atomicBool  // 使用者设置了写入超时，不再使用默认的写入超时
+	leased        time.Time   // 读出的时间
+	leaseTimer    *time.Timer // 租用超时
+	leaseMu       sync.Mutex  // 租用超时的回调可能在 leaseTimer 赋值之前运行，和读出原始连接互斥
+}
+
+// lease 租用超时，超过 MaxLease 或 LeaseIdleTimeout 强制关闭连接
+func (T *connSingle) lease() {
+	if T.cp.MaxLease == 0 && T.cp.LeaseIdleTimeout == 0 {
+		return
+	}
+	T.leased = time.Now()
+	atomic.StoreInt64(&T.active, T.leased.UnixNano())
+	T.leaseMu.Lock()
+	T.leaseTimer = time.AfterFunc(T.leaseRemain(T.leased), T.leaseCheck)
+	T.leaseMu.Unlock()
+}
+
+// leaseRemain 距离租用超时的时间，小于等于0表示已经超时
+func (T *connSingle) leaseRemain(now time.Time) time.Duration {
+	var remain time.Duration
+	if T.cp.MaxLease != 0 {
+		remain = T.cp.MaxLease - now.Sub(T.leased)
+	}
+	if T.cp.LeaseIdleTimeout != 0 {
+		active := time.Unix(0, atomic.LoadInt64(&T.active))
+		if idle := T.cp.LeaseIdleTimeout - now.Sub(active); T.cp.MaxLease == 0 || idle < remain {
+			remain = idle
+		}
+	}
+	return remain
+}
+
+func (T *connSingle) leaseCheck() {
+	// 等待 lease 中 leaseTimer 赋值完成，并和 rawConn 互斥
+	T.leaseMu.Lock()
+	if T.closed.isTrue() {
+		T.leaseMu.Unlock()
+		return
+	}
+	if remain := T.leaseRemain(time.Now()); remain > 0 {
+		T.leaseTimer.Reset(remain)
+		T.leaseMu.Unlock()
+		return
+	}
+
+	// 租用超时，废弃连接。关闭后阻塞的读写会返回错误。
+	T.expired.setTrue()
+	T.discard.setTrue()
+	err := T.Close()
+	T.leaseMu.Unlock()
+
+	if err == nil && T.cp.OnLeaseExpired != nil {
+		T.cp.OnLeaseExpired(T)
+	}
 }
 
 // Write 写入
@@ -65,9 +132,15 @@ func (T *connSingle) Write(b []byte) (n int, err error) {
 	if T.closed.isTrue() {
 		return 0, io.EOF
 	}
+	if T.leaseTimer != nil {
+		atomic.StoreInt64(&T.active, time.Now().UnixNano())
+	}
+	if T.cp.WriteTimeout != 0 && T.writeDeadline.isFalse() {
+		T.Conn.SetWriteDeadline(time.Now().Add(T.cp.WriteTimeout))
+	}
 	n, err = T.Conn.Write(b)
 	if ne, ok := err.(net.Error); ok && !ne.Timeout() {
-		T.discard.setTrue()
+		T.ioFailure()
 	}
 	return
 }
@@ -81,13 +154,26 @@ func (T *connSingle) Read(b []byte) (n int, err error) {
 	if T.closed.isTrue() {
 		return 0, io.EOF
 	}
+	if T.leaseTimer != nil {
+		atomic.StoreInt64(&T.active, time.Now().UnixNano())
+	}
+	if T.cp.ReadTimeout != 0 && T.readDeadline.isFalse() {
+		T.Conn.SetReadDeadline(time.Now().Add(T.cp.ReadTimeout))
+	}
 	n, err = T.Conn.Read(b)
 	if ne, ok := err.(net.Error); ok && !ne.Timeout() {
-		T.discard.setTrue()
+		T.ioFailure()
 	}
 	return
 }
 
+// ioFailure 读写错误，废弃连接，并记录服务后端的错误
+func (T *connSingle) ioFailure() {
+	if !T.discard.setTrue() && T.backend != nil {
+		T.backend.failure(T.cp)
+	}
+}
+
 // Close 关闭连接
 //
 //	error          错误
@@ -98,14 +184,24 @@ func (T *connSingle) Close() error {
 	if T.closed.setTrue() {
 		return errorConnClose
 	}
+	if T.leaseTimer != nil {
+		T.leaseTimer.Stop()
+	}
+	if T.backend != nil {
+		T.backend.release()
+	}
 
 	notifier, ok := T.Conn.(vconn.CloseNotifier)
-	if ok && T.discard.isFalse() {
+	if ok && T.discard.isFalse() && T.cp.ideConn() != 0 && (T.backend == nil || T.backend.removed.isFalse()) {
 		select {
 		case <-notifier.CloseNotify():
 			// 连接已经关闭
 		default:
-			if err := T.cp.putPoolConn(T.Conn, T.addr); err == nil {
+			if T.reset() != nil {
+				// 重置失败，废弃
+				break
+			}
+			if err := T.cp.putPoolConn(T.Conn, T.key, T.backend); err == nil {
 				// 回收成功
 				return nil
 			}
@@ -115,6 +211,17 @@ func (T *connSingle) Close() error {
 	return T.Conn.Close()
 }
 
+// reset 回收前清除读写超时，并重置会话，下一个使用者得到干净的连接
+func (T *connSingle) reset() error {
+	if err := T.Conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	if T.cp.Reset != nil {
+		return T.cp.Reset(T.Conn)
+	}
+	return nil
+}
+
 // LocalAddr 返回本地网络地址
 func (T *connSingle) LocalAddr() net.Addr {
 	return T.Conn.LocalAddr()
@@ -125,27 +232,31 @@ func (T *connSingle) RemoteAddr() net.Addr {
 	return T.Conn.RemoteAddr()
 }
 
-// SetDeadline 设置读写超时时间
+// SetDeadline 设置读写超时时间，设置后不再使用池的 ReadTimeout 和 WriteTimeout
 func (T *connSingle) SetDeadline(t time.Time) error {
 	if T.closed.isTrue() {
 		return errorConnClose
 	}
+	T.readDeadline.setTrue()
+	T.writeDeadline.setTrue()
 	return T.Conn.SetDeadline(t)
 }
 
-// SetReadDeadline 设置读取超时时间
+// SetReadDeadline 设置读取超时时间，设置后不再使用池的 ReadTimeout
 func (T *connSingle) SetReadDeadline(t time.Time) error {
 	if T.closed.isTrue() {
 		return errorConnClose
 	}
+	T.readDeadline.setTrue()
 	return T.Conn.SetReadDeadline(t)
 }
 
-// SetWriteDeadline 设置写入超时时间
+// SetWriteDeadline 设置写入超时时间，设置后不再使用池的 WriteTimeout
 func (T *connSingle) SetWriteDeadline(t time.Time) error {
 	if T.closed.isTrue() {
 		return errorConnClose
 	}
+	T.writeDeadline.setTrue()
 	return T.Conn.SetWriteDeadline(t)
 }
 
@@ -160,50 +271,131 @@ func (T *connSingle) IsReuseConn() bool {
 	return T.isPool
 }
 
-func (T *connSingle) rawConn() net.Conn {
-	if T.rawRead.setTrue() {
+// rawConn 读出连接。已经租用超时关闭，返回已关闭的连接，ok 为 false
+func (T *connSingle) rawConn() (conn net.Conn, ok bool) {
+	if T.rawRead.isTrue() {
 		panic(errorConnRAWRead)
 	}
+
+	// 停止租用超时，已经触发的回调等待读出完成后，看到连接已关闭
+	T.leaseMu.Lock()
+	defer T.leaseMu.Unlock()
+	if T.leaseTimer != nil {
+		T.leaseTimer.Stop()
+	}
+	if T.expired.isTrue() {
+		T.rawRead.setTrue()
+		return T.Conn, false
+	}
 	if T.closed.setTrue() {
 		panic(errorConnClose)
 	}
+	T.rawRead.setTrue()
+	if T.backend != nil {
+		T.backend.release()
+	}
 
+	// 不清除 cp，已经触发的租用超时还会读取它
 	atomic.AddInt32(&T.cp.connNum, -1)
-	conn := T.Conn
-	T.Conn = nil
-	T.cp = nil
-	T.addr = nil
-
-	return conn
+	return T.Conn, true
 }
 
 // 读出源始连接；如果是从池中读取出来，可能存在后台读取1位数据。这样你调用Read读取数据不完整，数据少一位。
+// 已经租用超时关闭，返回已关闭的连接。
 // p 将存放后台存取的数据，n 是后台数据长度。
 func (T *connSingle) RawConnFull(p []byte) (conn net.Conn, n int) {
-	conn = T.rawConn()
-	if conn, ok := conn.(*vconn.Conn); ok {
-		return conn.RawConnFull(p)
+	conn, ok := T.rawConn()
+	if !ok {
+		return conn, 0
 	}
-	return conn, 0
+	if c, ok := conn.(interface {
+		RawConnFull([]byte) (net.Conn, int)
+	}); ok {
+		conn, n = c.RawConnFull(p)
+	}
+	// 清除 ReadTimeout 和 WriteTimeout 设置的超时
+	conn.SetDeadline(time.Time{})
+	return conn, n
 }
 
 // 读出源始连接；如果是从池中读取出来，可能存在后台读取1位数据。这样你调用Read读取数据不完整，数据少一位。
+// 已经租用超时关闭，返回已关闭的连接。
 // 建议使用RawConnFull，当然你可以调用 IsReuseConn 判断是不是池中连接。
 func (T *connSingle) RawConn() net.Conn {
-	conn := T.rawConn()
-	if conn, ok := conn.(*vconn.Conn); ok {
-		return conn.RawConn()
+	conn, ok := T.rawConn()
+	if !ok {
+		return conn
 	}
+	if c, ok := conn.(interface{ RawConn() net.Conn }); ok {
+		conn = c.RawConn()
+	}
+	// 清除 ReadTimeout 和 WriteTimeout 设置的超时
+	conn.SetDeadline(time.Time{})
 	return conn
 }
 
+// ConnectionState TLS 连接状态，不是 TLS 连接 HandshakeComplete 为 false
+func (T *connSingle) ConnectionState() tls.ConnectionState {
+	if conn, ok := T.Conn.(TLSConn); ok {
+		return conn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+// tlsConn TLS 连接，保存握手后的连接状态
+type tlsConn struct {
+	*vconn.Conn
+	state tls.ConnectionState
+	cert  *tls.Certificate // 握手使用的客户端证书，会话恢复的连接为nil
+	// 配置使用 GetClientCertificate，cert 是被替换的证书，或者会话恢复的连接在证书更新之前开始握手，连接过期
+	getCert bool
+	certGen uint32 // 开始握手时的证书更新次数
+}
+
+func newTLSConn(conn *tls.Conn, cert *tls.Certificate, getCert bool, certGen uint32) *tlsConn {
+	return &tlsConn{Conn: vconn.New(conn), state: conn.ConnectionState(), cert: cert, getCert: getCert, certGen: certGen}
+}
+
+// ConnectionState TLS 连接状态
+func (T *tlsConn) ConnectionState() tls.ConnectionState {
+	return T.state
+}
+
 type connMan struct {
 	pools       *pools
 	conn        net.Conn
+	backend     *backend // 服务的后端，不是服务为nil
 	ctx         context.Context
 	ctxCancel   context.CancelFunc
 	unavailable atomicBool // 不可用
 	readyed     chan struct{}
+	putAt       time.Time   // 入池的时间
+	idleTimer   *time.Timer // 空闲超时，由 pools.mu 保护
+}
+
+// setIdleTimeout 设置空闲超时，从入池的时间开始计算，0为不超时
+func (T *connMan) setIdleTimeout(d time.Duration) {
+	if T.idleTimer != nil {
+		T.idleTimer.Stop()
+		T.idleTimer = nil
+	}
+	if d == 0 {
+		return
+	}
+	remain := time.Until(T.putAt.Add(d))
+	if remain <= 0 {
+		T.ctxCancel()
+		return
+	}
+	T.idleTimer = time.AfterFunc(remain, T.ctxCancel)
+}
+
+// placed 已经放入池中，设置空闲超时，增加后端的空闲连接数
+func (T *connMan) placed(idleTimeout time.Duration) {
+	if T.backend != nil {
+		atomic.AddInt32(&T.backend.idle, 1)
+	}
+	T.setIdleTimeout(idleTimeout)
 }
 
 func (T *connMan) notifyYield() {
@@ -270,12 +462,17 @@ func (T *pools) yield(conn net.Conn) {
 	if pos, ok := T.occupy[conn]; ok {
 		delete(T.occupy, conn)
 
+		cm := T.conns[pos]
+		cm.setIdleTimeout(0)
+		if cm.backend != nil {
+			atomic.AddInt32(&cm.backend.idle, -1)
+		}
 		T.conns[pos] = nil
 		T.vacancy[pos] = struct{}{}
 	}
 }
 
-func (T *pools) put(conn net.Conn, idleTImeout time.Duration) error {
+func (T *pools) put(conn net.Conn, be *backend, idleTImeout time.Duration) error {
 	T.mu.Lock()
 	defer T.mu.Unlock()
 
@@ -303,47 +500,41 @@ func (T *pools) put(conn net.Conn, idleTImeout time.Duration) error {
 	cm := &connMan{
 		pools:   T,
 		conn:    conn,
+		backend: be,
 		readyed: make(chan struct{}),
+		putAt:   time.Now(),
 	}
 
-	// 上下文
+	// 上下文，负责处理读出取消。空闲超时由 setIdleTimeout 处理，可以被 Reconfigure 修改
 	if T.ctx == nil {
 		T.ctx, T.ctxCancel = context.WithCancel(context.Background())
 	}
-	if idleTImeout != 0 {
-		// 负责处理空闲超时
-		cm.ctx, cm.ctxCancel = context.WithTimeout(T.ctx, idleTImeout)
-	} else {
-		// 负责处理读出取消
-		cm.ctx, cm.ctxCancel = context.WithCancel(T.ctx)
+	cm.ctx, cm.ctxCancel = context.WithCancel(T.ctx)
+	ideConn := T.cp.ideConn()
+
+	// 池中的连接等于或超出最大限制连接。按占用的数量判断，不按位置，Reconfigure 修改 IdeConn 后位置可能超出
+	if ideConn != 0 && len(T.occupy) >= ideConn {
+		cm.ctxCancel()
+		return ErrPoolFull
 	}
 
 	// 在空缺位置安放
 	for pos := range T.vacancy {
-
 		delete(T.vacancy, pos)
 
-		// 超出最大的空闲连接
-		if T.cp.IdeConn != 0 && pos >= T.cp.IdeConn {
-			continue
-		}
-
 		T.conns[pos] = cm
 		T.occupy[conn] = pos
+		cm.placed(idleTImeout)
 		go cm.notifyYield()
 		<-cm.readyed
 		return nil
 	}
 
-	// 池中的连接等于或超出最大限制连接
-	if T.cp.IdeConn != 0 && T.connsSize >= T.cp.IdeConn {
-		return ErrPoolFull
-	}
-
 	// 正常收回
 	T.conns = append(T.conns, cm)
 	T.occupy[conn] = T.connsSize
 	T.connsSize++
+	cm.placed(idleTImeout)
 	go cm.notifyYield()
 	<-cm.readyed
 	return nil
@@ -381,6 +572,18 @@ func (T *pools) clear() {
 	}
 }
 
+// closeFunc 关闭匹配的空闲连接
+func (T *pools) closeFunc(match func(cm *connMan) bool) {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+	for _, pos := range T.occupy {
+		if cm := T.conns[pos]; match(cm) {
+			// notifyYield 负责关闭连接并让位
+			cm.ctxCancel()
+		}
+	}
+}
+
 func ResolveAddr(network, address string) (net.Addr, error) {
 	switch network {
 	case "tcp":
@@ -395,43 +598,133 @@ func ResolveAddr(network, address string) (net.Addr, error) {
 	return nil, fmt.Errorf("the network type %s not support", network)
 }
 
+// RemoteResolveAddr 不在本地解析域名，用于代理拨号（如 SOCKS5Dialer）由代理解析域名。
+// 可以设置为 ConnPool.ResolveAddr 使用。
+//
+//	network string  连接类型
+//	address string  地址，格式为 host:port
+//	net.Addr        地址
+//	error           错误
+func RemoteResolveAddr(network, address string) (net.Addr, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, err
+	}
+	return NewAddr(network, address), nil
+}
+
+// addr 自定义地址
+type addr struct {
+	network string
+	address string
+}
+
+func (T *addr) Network() string { return T.network }
+func (T *addr) String() string  { return T.address }
+
+// NewAddr 创建一个不需要解析的地址，可以作为池的 key 使用，如代理ID
+//
+//	network string  连接类型
+//	address string  地址
+//	net.Addr        地址
+func NewAddr(network, address string) net.Addr {
+	return &addr{network: network, address: address}
+}
+
 func parseKey(network, address string) string {
 	return network + "," + address
 }
 
-// ConnPool 连接池
-type ConnPool struct {
-	Dialer                                                      // 拨号
-	ResolveAddr func(network, address string) (net.Addr, error) // 拨号地址变更
-	IdeConn     int                                             // 空闲连接数，0为不支持连接入池
-	IdeTimeout  time.Duration                                   // 空闲自动超时，0为不超时
-	MaxConn     int                                             // 最大连接数，0为无限制连接
-	connNum     int32                                           // 当前连接数
-	conns       map[string]*pools                               // 连接集
-	m           sync.Mutex                                      // 锁
-	closed      atomicBool                                      // 关闭池
-	inited      atomicBool                                      // 初始化
-	pool        sync.Pool                                       // 临时存在，存在空闲的池对象
+// dialArgs 拨号参数
+type dialArgs struct {
+	key        string       // 池的 key
+	network    string       // 连接类型
+	address    string       // 连接地址
+	tls        *tls.Config  // TLS 配置，为nil 不使用 TLS
+	tlsKey     string       // TLS 配置的 key，见 tlsRegistry.key
+	serverName string       // TLS 服务器名称
+	proxy      *ProxyHeader // PROXY 协议头，为nil 不发送
+	local      net.Addr     // 本地地址，为nil 由系统选择
+	svc        *service     // 服务，不是服务为nil
+	backend    *backend     // 服务选择的后端
 }
 
-func (T *ConnPool) init() {
-	if T.inited.setTrue() {
-		return
+// parseKey 池的 key，TLS 连接附加服务器名称、ALPN 和配置
+func (T *dialArgs) parseKey() string {
+	key := parseKey(T.network, T.address)
+	if T.tls != nil {
+		key += ",tls," + T.serverName + "," + T.tlsKey
 	}
-	if T.conns == nil {
-		T.conns = make(map[string]*pools)
+	if T.proxy != nil {
+		key += ",proxy," + T.proxy.key()
 	}
-	if T.Dialer == nil {
-		T.Dialer = new(net.Dialer)
+	if T.local != nil {
+		key += ",local," + T.local.String()
 	}
+	return key
+}
+
+// ConnPool 连接池
+type ConnPool struct {
+	Dialer                                                              // 拨号
+	ResolveAddr         func(network, address string) (net.Addr, error) // 拨号地址变更
+	IdeConn             int                                             // 空闲连接数，0为不支持连接入池
+	IdeTimeout          time.Duration                                   // 空闲自动超时，0为不超时
+	MaxConn             int                                             // 最大连接数，0为无限制连接
+	TLSConfig           *tls.Config                                     // DialTLSContext 的 config 为nil 时使用，为nil 使用默认配置
+	TLSHandshakeTimeout time.Duration                                   // TLS 握手超时，0为不超时
+	TLSSessionCache     int                                             // 每个 key 的 TLS 会话缓存容量，0为不缓存。tls.Config 设置了 ClientSessionCache 则使用它
+	OnDial              func(ctx context.Context, conn net.Conn) error  // 新建连接后调用，用于握手或认证，返回错误则关闭连接
+	Reset               func(conn net.Conn) error                       // 连接回收前调用，用于重置会话状态，返回错误则废弃连接
+	ReadTimeout         time.Duration                                   // 读出的连接每次读取的超时，0为不超时。使用者设置了读取超时则不使用
+	WriteTimeout        time.Duration                                   // 读出的连接每次写入的超时，0为不超时。使用者设置了写入超时则不使用
+	LeaseIdleTimeout    time.Duration                                   // 读出的连接没有读写超过该时间，强制关闭，0为不限制
+	MaxLease            time.Duration                                   // 读出的连接最长使用时间，超过强制关闭，0为不限制
+	OnLeaseExpired      func(conn net.Conn)                             // 租用超时强制关闭连接后调用
+	SocketOptions       *SocketOptions                                  // TCP 连接选项，用于拨号和 Put 的连接，nil为不修改
+	AddrSocketOptions   map[string]*SocketOptions                       // 按地址覆盖 SocketOptions，key 为 address（如 127.0.0.1:80）
+	HedgeDial           *HedgeDial                                      // 对冲拨号，nil为不启用
+	SlowStart           *SlowStart                                      // 服务新加入或剔除后恢复的后端慢启动，nil为不启用
+	OutlierDetection    *OutlierDetection                               // 服务的异常后端剔除，nil为不剔除
+	LocalAddr           LocalAddrPolicy                                 // 拨号使用的本地地址，nil为系统选择
+	AddrLocalAddr       map[string]LocalAddrPolicy                      // 按地址覆盖 LocalAddr，key 为 address（如 127.0.0.1:80）
+	ProxyProtocol       int                                             // 新建连接发送 PROXY 协议头的版本（1 或 2），0为不发送。地址由 ProxyHeaderContextKey 传递
+	connNum             int32                                           // 当前连接数
+	conns               map[string]*pools                               // 连接集
+	m                   sync.Mutex                                      // 锁
+	closed              atomicBool                                      // 关闭池
+	inited              sync.Once                                       // 初始化
+	pool                sync.Pool                                       // 临时存在，存在空闲的池对象
+	sessions            map[string]tls.ClientSessionCache               // TLS 会话缓存，最多 tlsSessionsMax 个 key，关闭空闲连接时清空
+	tlsFull             int64                                           // TLS 完整握手次数
+	tlsResumed          int64                                           // TLS 会话恢复次数
+	services            map[string]*service                             // 服务
+	sm                  sync.RWMutex                                    // 服务锁
+	latency             map[string]*dialLatency                         // 拨号时间，用于对冲拨号，最多 dialLatencyMax 个地址，关闭空闲连接时清空
+	hm                  sync.Mutex                                      // 拨号时间锁
+	hedgeStarted        int32                                           // 开始对冲拨号的次数
+	hedgeWon            int32                                           // 对冲拨号先完成的次数
+	limits              atomic.Value                                    // Reconfigure 设置的限制 *poolLimits
+	tlsKeys             tlsRegistry                                     // TLS 配置的 key
+	certRetired         []*tls.Certificate                              // CertReloader 替换的证书，最多 certRetiredMax 个
+	certGen             uint32                                          // CertReloader 更新证书的次数
+}
+
+func (T *ConnPool) init() {
+	T.inited.Do(func() {
+		if T.conns == nil {
+			T.conns = make(map[string]*pools)
+		}
+		if T.Dialer == nil {
+			T.Dialer = new(net.Dialer)
+		}
+	})
 }
 
-func (T *ConnPool) getPoolConn(network, address string) (conn net.Conn, err error) {
+func (T *ConnPool) getPoolConn(key string) (conn net.Conn, err error) {
 	T.m.Lock()
 	defer T.m.Unlock()
 	T.init()
 
-	key := parseKey(network, address)
 	ps, ok := T.conns[key]
 	if !ok {
 		return nil, ErrConnNotAvailable
@@ -445,9 +738,10 @@ func (T *ConnPool) getPoolConn(network, address string) (conn net.Conn, err erro
 	return
 }
 
-func (T *ConnPool) putPoolConn(conn net.Conn, addr net.Addr) error {
+func (T *ConnPool) putPoolConn(conn net.Conn, key string, be *backend) error {
 	// 空闲连接限制
-	if T.IdeConn == 0 {
+	ideConn := T.ideConn()
+	if ideConn == 0 {
 		return ErrPoolFull
 	}
 
@@ -455,7 +749,11 @@ func (T *ConnPool) putPoolConn(conn net.Conn, addr net.Addr) error {
 	defer T.m.Unlock()
 	T.init()
 
-	key := parseKey(addr.Network(), addr.String())
+	// 证书更新时还在握手的连接
+	if tc, ok := conn.(*tlsConn); ok && T.certExpired(tc) {
+		return errorCertRetired
+	}
+
 	ps, ok := T.conns[key]
 	if !ok {
 		if inf := T.pool.Get(); inf != nil {
@@ -463,21 +761,20 @@ func (T *ConnPool) putPoolConn(conn net.Conn, addr net.Addr) error {
 		} else {
 			ps = &pools{
 				cp:      T,
-				occupy:  make(map[net.Conn]int),         // 占据位置
-				vacancy: make(map[int]struct{}),         // 空缺位置
-				conns:   make([]*connMan, 0, T.IdeConn), // 存在
+				occupy:  make(map[net.Conn]int),       // 占据位置
+				vacancy: make(map[int]struct{}),       // 空缺位置
+				conns:   make([]*connMan, 0, ideConn), // 存在
 			}
 		}
 		T.conns[key] = ps
 	}
-	return ps.put(conn, T.IdeTimeout)
+	return ps.put(conn, be, T.ideTimeout())
 }
 
-func (T *ConnPool) getPoolConnCount(network, address string) int {
+func (T *ConnPool) getPoolConnCount(key string) int {
 	T.m.Lock()
 	defer T.m.Unlock()
 
-	key := parseKey(network, address)
 	pools, ok := T.conns[key]
 	if !ok {
 		return 0
@@ -493,6 +790,57 @@ func (T *ConnPool) clearPoolConn() {
 		delete(T.conns, key)
 		T.pool.Put(pools)
 	}
+	T.sessions = nil
+
+	T.hm.Lock()
+	T.latency = nil
+	T.hm.Unlock()
+}
+
+// clientCertChanged CertReloader 的证书 old 被替换，清空 TLS 会话缓存（会话恢复不会重新发送证书），
+// 并关闭使用 old 握手的空闲连接，以及证书更新之前开始握手的会话恢复连接（不知道会话使用的证书）。
+// 其它证书的连接不受影响
+func (T *ConnPool) clientCertChanged(old *tls.Certificate) {
+	T.m.Lock()
+	T.sessions = nil
+	if len(T.certRetired) >= certRetiredMax {
+		T.certRetired = T.certRetired[1:]
+	}
+	T.certRetired = append(T.certRetired, old)
+	atomic.AddUint32(&T.certGen, 1)
+	T.m.Unlock()
+
+	T.closeIdle(func(key string, conn net.Conn) bool {
+		tc, ok := conn.(*tlsConn)
+		return ok && T.certExpired(tc)
+	})
+}
+
+// certExpired 连接的客户端证书已被替换，需要在 T.m 锁中调用
+func (T *ConnPool) certExpired(tc *tlsConn) bool {
+	if !tc.getCert {
+		return false
+	}
+	if tc.cert == nil {
+		return tc.certGen != atomic.LoadUint32(&T.certGen)
+	}
+	for _, cert := range T.certRetired {
+		if cert == tc.cert {
+			return true
+		}
+	}
+	return false
+}
+
+// closeIdle 关闭匹配的空闲连接
+func (T *ConnPool) closeIdle(match func(key string, conn net.Conn) bool) {
+	T.m.Lock()
+	defer T.m.Unlock()
+	for key, pools := range T.conns {
+		pools.closeFunc(func(cm *connMan) bool {
+			return match(key, cm.conn)
+		})
+	}
 }
 
 func (T *ConnPool) parseAddr(network, address string) (net.Addr, error) {
@@ -516,6 +864,8 @@ func (T *ConnPool) Dial(network, address string) (net.Conn, error) {
 // 注意：远程地址支持 host 或 ip，一个 host 会有多个 ip 地址，所以无法用 host 的 ip 做为存储地址。
 // DialContext 支持 hsot 和 ip 读取或创建连接。 而.Get 仅支持 ip 读取池中连接。
 // DialContext 创建的连接，调用 Close 关闭后，自动收回。
+// 设置了 LocalAddr，池的 key 包含选择的本地地址，只复用该本地地址的连接。
+// address 是 SetService 设置的服务名称，使用服务的负载均衡选择一个后端。
 //
 //	ctx context.Context 上下文
 //	network string      连接类型
@@ -523,70 +873,308 @@ func (T *ConnPool) Dial(network, address string) (net.Conn, error) {
 //	net.Conn            连接
 //	error               错误
 func (T *ConnPool) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return T.dialContext(ctx, &dialArgs{network: network, address: address})
+}
+
+// DialTLS 见 DialTLSContext
+//
+//	network string      连接类型
+//	address string      连接地址
+//	config *tls.Config  TLS 配置
+//	net.Conn            连接
+//	error               错误
+func (T *ConnPool) DialTLS(network, address string, config *tls.Config) (net.Conn, error) {
+	return T.DialTLSContext(context.Background(), network, address, config)
+}
+
+// DialTLSContext 拨号 TLS 连接，用法同 DialContext。
+// 池的 key 包含服务器名称、ALPN 和 config 的内容（证书、根证书池、版本等），所以 TCP 连接和不同配置的 TLS 连接不会混用。
+// config 设置了回调函数（如 GetClientCertificate），同一个 *tls.Config 才是同一个配置。
+// config.ServerName 为空，使用 address 的 host 作为服务器名称。
+// TLS 握手在拨号中完成，超时为 TLSHandshakeTimeout 或 ctx 的截止时间。
+//
+//	ctx context.Context 上下文
+//	network string      连接类型
+//	address string      连接地址
+//	config *tls.Config  TLS 配置，为nil 使用 TLSConfig
+//	net.Conn            连接，可以使用 Conn.ConnectionState 读取 TLS 连接状态
+//	error               错误
+func (T *ConnPool) DialTLSContext(ctx context.Context, network, address string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		config = T.TLSConfig
+	}
+	if config == nil {
+		config = defaultTLSConfig
+	}
+	serverName := config.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		serverName = host
+	}
+	return T.dialContext(ctx, &dialArgs{network: network, address: address, tls: config, tlsKey: T.tlsKeys.key(config), serverName: serverName})
+}
+
+func (T *ConnPool) dialContext(ctx context.Context, da *dialArgs) (net.Conn, error) {
 	if T.closed.isTrue() {
 		return nil, errorConnPoolClose
 	}
 
-	addr, err := T.parseAddr(network, address)
-	if err != nil {
-		return nil, err
+	// 服务，选择一个后端
+	if svc := T.getService(da.address); svc != nil {
+		be, err := svc.pick(ctx, T, nil)
+		if err != nil {
+			return nil, err
+		}
+		da.svc, da.backend, da.address = svc, be, be.Address
 	}
 
-	T.init()
+	if err := T.prepare(ctx, da); err != nil {
+		if da.backend != nil {
+			da.backend.release()
+		}
+		return nil, err
+	}
 
 	var (
 		conn net.Conn
 		pool bool
+		err  error
 	)
 
 	if priority, _ := ctx.Value(PriorityContextKey).(bool); priority {
 		// 新建拨号
-		conn, err = T.dialCtx(ctx, network, addr.String())
+		conn, da, err = T.dialHedged(ctx, da)
 	} else {
 		// 读取不存在，新建拨号
-		conn, pool, err = T.getConn(ctx, network, addr.String())
+		conn, da, pool, err = T.getConn(ctx, da)
 	}
 	if err != nil {
+		T.dialFailed(ctx, da, err)
 		return nil, err
 	}
+	if da.backend != nil {
+		da.backend.success(T)
+	}
+
+	cs := &connSingle{Conn: conn, cp: T, isPool: pool, key: da.key, backend: da.backend}
+	cs.lease()
+	return cs, nil
+}
+
+// prepare 解析地址，生成池的 key
+func (T *ConnPool) prepare(ctx context.Context, da *dialArgs) error {
+	addr, err := T.parseAddr(da.network, da.address)
+	if err != nil {
+		return err
+	}
+
+	T.init()
+
+	da.address = addr.String()
+	if T.ProxyProtocol != 0 {
+		if ph, ok := ctx.Value(ProxyHeaderContextKey).(*ProxyHeader); ok && ph != nil && ph.Source != nil {
+			da.proxy = ph
+		}
+	}
+	da.local = T.localAddr(da.network, da.address)
+	da.key = da.parseKey()
+	return nil
+}
 
-	return &connSingle{Conn: conn, cp: T, isPool: pool, addr: addr}, nil
+// dialFailed 拨号失败，减少服务后端使用中的连接数，并记录错误
+func (T *ConnPool) dialFailed(ctx context.Context, da *dialArgs, err error) {
+	if da.backend == nil {
+		return
+	}
+	da.backend.release()
+	if err != ErrConnPoolMax && ctx.Err() == nil {
+		da.backend.failure(T)
+	}
 }
 
-func (T *ConnPool) dialCtx(ctx context.Context, network, address string) (conn net.Conn, err error) {
-	if T.MaxConn != 0 && int(atomic.LoadInt32(&T.connNum)) >= T.MaxConn {
+func (T *ConnPool) dialCtx(ctx context.Context, da *dialArgs) (conn net.Conn, err error) {
+	maxConn := T.maxConn()
+	if maxConn != 0 && int(atomic.LoadInt32(&T.connNum)) >= maxConn {
 		return nil, ErrConnPoolMax
 	}
 
-	conn, err = T.Dialer.DialContext(ctx, network, address)
+	dialer := T.Dialer
+	if da.local != nil {
+		dialer = localDialer(dialer, da.local)
+		ctx = context.WithValue(ctx, LocalAddrContextKey, da.local)
+	}
+	conn, err = dialer.DialContext(ctx, da.network, da.address)
 	if err != nil {
 		return
 	}
 
 	// 支持多线程拨号，防止网络阻塞，无法继续创建
 	// 再次判断连接数是否已经超出
-	if int(atomic.AddInt32(&T.connNum, 1)) > T.MaxConn && T.MaxConn != 0 { // 注意：判断位置不要交换
+	if int(atomic.AddInt32(&T.connNum, 1)) > maxConn && maxConn != 0 { // 注意：判断位置不要交换
 		atomic.AddInt32(&T.connNum, -1)
 		conn.Close()
 		return nil, ErrConnPoolMax
 	}
 
+	if err = T.applySocketOptions(conn, da.address); err != nil {
+		atomic.AddInt32(&T.connNum, -1)
+		conn.Close()
+		return nil, err
+	}
+
+	if da.proxy != nil {
+		dst := da.proxy.Destination
+		if dst == nil {
+			dst = conn.RemoteAddr()
+		}
+		if err = writeProxyHeader(conn, T.ProxyProtocol, da.proxy.Source, dst); err != nil {
+			atomic.AddInt32(&T.connNum, -1)
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	var (
+		tc   *tls.Conn
+		cert *tls.Certificate
+	)
+	certGen := atomic.LoadUint32(&T.certGen)
+	if da.tls != nil {
+		tc, cert, err = T.handshake(ctx, conn, da)
+		if err != nil {
+			atomic.AddInt32(&T.connNum, -1)
+			return nil, err
+		}
+		conn = tc
+	}
+
+	// 初始化连接，如认证
+	if T.OnDial != nil {
+		if err = T.OnDial(ctx, conn); err != nil {
+			atomic.AddInt32(&T.connNum, -1)
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if tc != nil {
+		return newTLSConn(tc, cert, da.tls.GetClientCertificate != nil, certGen), nil
+	}
 	return vconn.New(conn), nil
 }
 
-func (T *ConnPool) getConn(ctx context.Context, network, address string) (conn net.Conn, pool bool, err error) {
-	if T.getPoolConnCount(network, address) > 0 {
-		if conn, err = T.getPoolConn(network, address); err == nil {
-			pool = true
-			return
+// handshake TLS 握手，失败关闭连接
+func (T *ConnPool) handshake(ctx context.Context, conn net.Conn, da *dialArgs) (*tls.Conn, *tls.Certificate, error) {
+	config := da.tls.Clone()
+	config.ServerName = da.serverName
+	if config.ClientSessionCache == nil && T.TLSSessionCache != 0 {
+		config.ClientSessionCache = T.sessionCache(da.key)
+	}
+
+	// 记录使用的客户端证书，证书更新后可以识别旧连接
+	var cert *tls.Certificate
+	if get := config.GetClientCertificate; get != nil {
+		config.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			c, err := get(cri)
+			cert = c
+			return c, err
 		}
 	}
-	conn, err = T.dialCtx(ctx, network, address)
+
+	timeout := T.TLSHandshakeTimeout
+	if d, ok := ctx.Value(tlsHandshakeTimeoutContextKey).(time.Duration); ok && d != 0 && (timeout == 0 || d < timeout) {
+		timeout = d
+	}
+	var deadline time.Time
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	tc := tls.Client(conn, config)
+	if !deadline.IsZero() {
+		tc.SetDeadline(deadline)
+	}
+	if err := tc.Handshake(); err != nil {
+		tc.Close()
+		return nil, nil, err
+	}
+	if !deadline.IsZero() {
+		tc.SetDeadline(time.Time{})
+	}
+	if tc.ConnectionState().DidResume {
+		atomic.AddInt64(&T.tlsResumed, 1)
+	} else {
+		atomic.AddInt64(&T.tlsFull, 1)
+	}
+	return tc, cert, nil
+}
+
+// applySocketOptions 设置连接的选项，AddrSocketOptions 优先
+func (T *ConnPool) applySocketOptions(conn net.Conn, address string) error {
+	opts := T.SocketOptions
+	if o, ok := T.AddrSocketOptions[address]; ok {
+		opts = o
+	}
+	if opts == nil {
+		return nil
+	}
+	return opts.apply(conn)
+}
+
+// localAddr 选择本地地址，AddrLocalAddr 优先
+func (T *ConnPool) localAddr(network, address string) net.Addr {
+	policy := T.LocalAddr
+	if p, ok := T.AddrLocalAddr[address]; ok {
+		policy = p
+	}
+	if policy == nil {
+		return nil
+	}
+	return policy.LocalAddr(network, address)
+}
+
+// sessionCache 读取 key 的 TLS 会话缓存，不存在则创建
+func (T *ConnPool) sessionCache(key string) tls.ClientSessionCache {
+	T.m.Lock()
+	defer T.m.Unlock()
+
+	if T.sessions == nil {
+		T.sessions = make(map[string]tls.ClientSessionCache)
+	}
+	cache, ok := T.sessions[key]
+	if !ok {
+		if len(T.sessions) >= tlsSessionsMax {
+			// key 太多，删除任意一个
+			for k := range T.sessions {
+				delete(T.sessions, k)
+				break
+			}
+		}
+		cache = tls.NewLRUClientSessionCache(T.TLSSessionCache)
+		T.sessions[key] = cache
+	}
+	return cache
+}
+
+func (T *ConnPool) getConn(ctx context.Context, da *dialArgs) (conn net.Conn, dda *dialArgs, pool bool, err error) {
+	if T.getPoolConnCount(da.key) > 0 {
+		if conn, err = T.getPoolConn(da.key); err == nil {
+			return conn, da, true, nil
+		}
+	}
+	conn, dda, err = T.dialHedged(ctx, da)
 	return
 }
 
 // Get 从池中读取一条连接。读取出来的连接不会自动回收，如果你.Close() 是真的关闭连接，不是回收。
 // 注意：池中有可用连接数量，而无法读出连接。原因是连接存在后台数据，被判断为不完整。
+// 没有 TCP 连接时，读取 Put 的 TLS 连接。
 //
 //	addr net.Addr   地址，为远程地址RemoteAddr
 //	conn net.Conn	连接，源是 *vconn.Conn 类型
@@ -596,7 +1184,12 @@ func (T *ConnPool) Get(addr net.Addr) (conn net.Conn, err error) {
 		return nil, errorConnPoolClose
 	}
 
-	conn, err = T.getPoolConn(addr.Network(), addr.String())
+	key := parseKey(addr.Network(), addr.String())
+	conn, err = T.getPoolConn(key)
+	if err == ErrConnNotAvailable {
+		// Put 的 TLS 连接
+		conn, err = T.getPoolConn(key + tlsPutKey)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -615,6 +1208,7 @@ func (T *ConnPool) Add(conn net.Conn) error {
 
 // Put 增加一个连接到池中，适用于 Dial 和 listen 的连接。
 // Dial 连接使用RemoteAddr，listen 连接使用LocalAddr 为做 addr
+// *tls.Conn 使用单独的 key 存放，只能由 Get 读取，DialContext 和 DialTLSContext 不会读取到它
 //
 //	conn net.Conn   连接
 //	addr net.Addr	地址，作为池的 key 存放
@@ -624,7 +1218,7 @@ func (T *ConnPool) Put(conn net.Conn, addr net.Addr) error {
 		return errorConnPoolClose
 	}
 
-	if T.MaxConn != 0 && int(atomic.LoadInt32(&T.connNum)) >= T.MaxConn {
+	if maxConn := T.maxConn(); maxConn != 0 && int(atomic.LoadInt32(&T.connNum)) >= maxConn {
 		return ErrConnPoolMax
 	}
 
@@ -633,8 +1227,23 @@ func (T *ConnPool) Put(conn net.Conn, addr net.Addr) error {
 		return c.Close()
 	}
 
+	if err := T.applySocketOptions(conn, addr.String()); err != nil {
+		return err
+	}
+
+	var (
+		pc  net.Conn
+		key = parseKey(addr.Network(), addr.String())
+	)
+	if tc, ok := conn.(*tls.Conn); ok {
+		pc = newTLSConn(tc, nil, false, 0)
+		key += tlsPutKey
+	} else {
+		pc = vconn.New(conn)
+	}
+
 	atomic.AddInt32(&T.connNum, 1)
-	if err := T.putPoolConn(vconn.New(conn), addr); err != nil {
+	if err := T.putPoolConn(pc, key, nil); err != nil {
 		atomic.AddInt32(&T.connNum, -1)
 		return err
 	}
@@ -663,10 +1272,18 @@ func (T *ConnPool) ConnNumIde(network, address string) int {
 	if err != nil {
 		return 0
 	}
-	return T.getPoolConnCount(network, addr.String())
+	return T.getPoolConnCount(parseKey(network, addr.String()))
+}
+
+// TLSHandshakes TLS 握手次数
+//
+//	full int        完整握手次数
+//	resumed int     会话恢复次数
+func (T *ConnPool) TLSHandshakes() (full, resumed int) {
+	return int(atomic.LoadInt64(&T.tlsFull)), int(atomic.LoadInt64(&T.tlsResumed))
 }
 
-// CloseIdleConnections 关闭空闲连接池
+// CloseIdleConnections 关闭空闲连接池，并清空 TLS 会话缓存和对冲拨号的拨号时间
 func (T *ConnPool) CloseIdleConnections() {
 	T.clearPoolConn()
 }
@@ -688,3 +1305,24 @@ type contextKey struct {
 func (T *contextKey) String() string { return "connpool context value " + T.name }
 
 var PriorityContextKey = &contextKey{"priority"}
+
+// ProxyHeaderContextKey 上下文的Key，值为 *ProxyHeader，用于发送 PROXY 协议头
+var ProxyHeaderContextKey = &contextKey{"proxy-header"}
+
+// LocalAddrContextKey 上下文的Key，值为 net.Addr，是 LocalAddr 选择的本地地址。
+// Dialer 不是 *net.Dialer 时，可以读取它绑定本地地址
+var LocalAddrContextKey = &contextKey{"local-addr"}
+
+// tlsHandshakeTimeoutContextKey 上下文的Key，值为 time.Duration，是本次拨号的 TLS 握手超时，和 TLSHandshakeTimeout 取较小的
+var tlsHandshakeTimeoutContextKey = &contextKey{"tls-handshake-timeout"}
+
+var defaultTLSConfig = new(tls.Config)
+
+// tlsSessionsMax TLS 会话缓存的 key 数量上限
+const tlsSessionsMax = 1024
+
+// certRetiredMax 记录被替换的客户端证书的数量上限，只用于识别证书更新时还在握手的连接
+const certRetiredMax = 16
+
+// tlsPutKey Put 的 TLS 连接附加的 key
+const tlsPutKey = ",tls"
@@ -2,7 +2,9 @@ package vconnpool
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -310,7 +312,7 @@ func Test_pools_1(t *testing.T) {
 		}
 
 		conn.Close()
-		ps.put(conn, 5*time.Second)
+		ps.put(conn, nil, 5*time.Second)
 
 		as.Equal(ps.length(), 0)
 
@@ -337,7 +339,7 @@ func Test_pools_2(t *testing.T) {
 			conns:   make([]*connMan, 0, 10), // 存在
 		}
 
-		ps.put(conn, 5*time.Second)
+		ps.put(conn, nil, 5*time.Second)
 
 		conn.Close()
 		time.Sleep(10 * time.Millisecond)
@@ -348,3 +350,240 @@ func Test_pools_2(t *testing.T) {
 		as.ErrorIs(err, ErrConnNotAvailable).Nil(conn1)
 	})
 }
+
+// 新建连接后认证，认证失败关闭连接
+func Test_ConnPool_OnDial(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		defer c.Close()
+		b := make([]byte, 4)
+		if _, err := c.Read(b); err != nil {
+			return
+		}
+		if string(b) != "AUTH" {
+			c.Write([]byte("ERR"))
+			return
+		}
+		c.Write([]byte("+OK"))
+		<-vconn.New(c).CloseNotify()
+	}, func(raddr net.Addr) {
+		var auth string
+		cp := &ConnPool{
+			IdeConn: 5,
+			OnDial: func(ctx context.Context, conn net.Conn) error {
+				if _, err := conn.Write([]byte(auth)); err != nil {
+					return err
+				}
+				b := make([]byte, 3)
+				if _, err := conn.Read(b); err != nil {
+					return err
+				}
+				if string(b) != "+OK" {
+					return errors.New(string(b))
+				}
+				return nil
+			},
+		}
+		defer cp.Close()
+
+		auth = "AUTH"
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		conn.Close()
+		as.Equal(cp.ConnNum(), 1)
+
+		// 从池中读取，不再认证
+		auth = "BAD!"
+		conn, err = cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.True(conn.(Conn).IsReuseConn())
+
+		// 新建连接认证失败
+		_, err = cp.Dial(raddr.Network(), raddr.String())
+		as.Error(err)
+		as.Equal(cp.ConnNum(), 1)
+		conn.Close()
+	})
+}
+
+// 回收前清除读写超时并重置会话，重置失败废弃连接
+func Test_ConnPool_Reset(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		var resets int
+		cp := &ConnPool{
+			IdeConn: 5,
+			Reset: func(conn net.Conn) error {
+				resets++
+				if resets > 1 {
+					return errors.New("reset failed")
+				}
+				return nil
+			},
+		}
+		defer cp.Close()
+
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		conn.SetReadDeadline(time.Now())
+		conn.Close()
+		as.Equal(resets, 1).Equal(cp.ConnNum(), 1)
+
+		// 读取超时已经清除
+		conn, err = cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.True(conn.(Conn).IsReuseConn())
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		start := time.Now()
+		_, err = conn.Read(make([]byte, 1))
+		as.Error(err)
+		as.True(time.Since(start) >= 5*time.Millisecond)
+
+		conn.Close()
+		as.Equal(resets, 2).Equal(cp.ConnNum(), 0)
+	})
+}
+
+// 默认的读取超时，使用者设置了超时则不使用
+func Test_ConnPool_ReadTimeout(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{
+			IdeConn:     5,
+			ReadTimeout: 10 * time.Millisecond,
+		}
+		defer cp.Close()
+
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		_, err = conn.Read(make([]byte, 1))
+		ne, ok := err.(net.Error)
+		as.True(ok && ne.Timeout())
+
+		start := time.Now()
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		as.Error(err)
+		as.True(time.Since(start) >= 40*time.Millisecond)
+
+		// 超时不废弃连接
+		conn.Close()
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 1)
+	})
+}
+
+// 租用超时，强制关闭连接
+func Test_ConnPool_MaxLease(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		expired := make(chan net.Conn, 2)
+		cp := &ConnPool{
+			IdeConn:          5,
+			MaxLease:         100 * time.Millisecond,
+			LeaseIdleTimeout: 30 * time.Millisecond,
+			OnLeaseExpired: func(conn net.Conn) {
+				expired <- conn
+			},
+		}
+		defer cp.Close()
+
+		// 持续读写，超过 MaxLease 关闭
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		start := time.Now()
+		for {
+			if _, err = conn.Write([]byte("x")); err != nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		as.True(time.Since(start) >= 90*time.Millisecond)
+		as.Equal(<-expired, conn)
+		as.Equal(cp.ConnNum(), 0)
+
+		// 阻塞读取，超过 LeaseIdleTimeout 关闭
+		conn, err = cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		start = time.Now()
+		_, err = conn.Read(make([]byte, 1))
+		as.Error(err)
+		as.True(time.Since(start) < 90*time.Millisecond)
+		as.Equal(<-expired, conn)
+		as.ErrorIs(conn.Close(), errorConnClose)
+		as.Equal(cp.ConnNum(), 0)
+	})
+}
+
+// 读出原始连接和租用超时同时发生
+func Test_ConnPool_MaxLease_rawConn(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		var expired int32
+		cp := &ConnPool{
+			MaxLease: time.Millisecond,
+			OnLeaseExpired: func(conn net.Conn) {
+				atomic.AddInt32(&expired, 1)
+			},
+		}
+		defer cp.Close()
+
+		raw := 0
+		for i := 0; i < 100; i++ {
+			conn, err := cp.Dial(raddr.Network(), raddr.String())
+			as.NotError(err)
+			time.Sleep(time.Millisecond)
+			// 已经租用超时关闭，读出的是已关闭的连接
+			rc := conn.(Conn).RawConn()
+			if _, err := rc.Write([]byte("x")); err == nil {
+				raw++
+			}
+			rc.Close()
+		}
+		time.Sleep(10 * time.Millisecond)
+		as.Equal(int(atomic.LoadInt32(&expired))+raw, 100)
+		as.Equal(cp.ConnNum(), 0)
+	})
+}
+
+// 读出的原始连接不保留 ReadTimeout 设置的超时
+func Test_ConnPool_ReadTimeout_rawConn(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		time.Sleep(100 * time.Millisecond)
+		c.Write([]byte("x"))
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{ReadTimeout: 20 * time.Millisecond}
+		defer cp.Close()
+
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		_, err = conn.Read(make([]byte, 1))
+		as.Error(err)
+
+		rc := conn.(Conn).RawConn()
+		defer rc.Close()
+		b := make([]byte, 1)
+		_, err = rc.Read(b)
+		as.NotError(err).Equal(string(b), "x")
+	})
+}
@@ -0,0 +1,142 @@
+package vconnpool
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPProxyDialer HTTP CONNECT 代理拨号，可以设置为 ConnPool.Dialer 使用。
+// 池的 key 是目标地址，相同目标的隧道连接可以复用。
+// ConnPool.ResolveAddr 设置为 RemoteResolveAddr，域名不在本地解析，由代理解析。
+type HTTPProxyDialer struct {
+	Dialer    Dialer      // 连接代理的拨号，为nil 使用 net.Dialer
+	Network   string      // 代理连接类型，为空使用 tcp
+	Address   string      // 代理地址
+	Username  string      // 用户名，为空不设置 Proxy-Authorization
+	Password  string      // 密码
+	Header    http.Header // CONNECT 请求附加的标头
+	TLSConfig *tls.Config // 不为nil，使用 TLS 连接代理
+}
+
+// Dial 见 DialContext
+//
+//	network string      连接类型
+//	address string      目标地址
+//	net.Conn            连接
+//	error               错误
+func (T *HTTPProxyDialer) Dial(network, address string) (net.Conn, error) {
+	return T.DialContext(context.Background(), network, address)
+}
+
+// DialContext 通过代理建立隧道连接目标地址
+//
+//	ctx context.Context 上下文
+//	network string      连接类型，仅支持 tcp, tcp4, tcp6
+//	address string      目标地址，支持域名
+//	net.Conn            连接
+//	error               错误
+func (T *HTTPProxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("the network type %s not support", network)
+	}
+
+	dialer := T.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	proxyNetwork := T.Network
+	if proxyNetwork == "" {
+		proxyNetwork = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, proxyNetwork, T.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	if T.TLSConfig != nil {
+		config := T.TLSConfig
+		if config.ServerName == "" {
+			config = config.Clone()
+			host, _, err := net.SplitHostPort(T.Address)
+			if err != nil {
+				host = T.Address
+			}
+			config.ServerName = host
+		}
+		tc := tls.Client(conn, config)
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	conn, err = T.connect(conn, address)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+// connect 发送 CONNECT 请求，失败关闭连接
+func (T *HTTPProxyDialer) connect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	for k, v := range T.Header {
+		req.Header[k] = v
+	}
+	if T.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(T.Username + ":" + T.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("vconnpool: proxy connect %s failed, %s", address, resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		// 代理已经发送了目标的数据
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 先读取缓冲中的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (T *bufferedConn) Read(b []byte) (int, error) {
+	return T.r.Read(b)
+}
@@ -0,0 +1,116 @@
+package vconnpool
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/456vv/x/tcptest"
+	"github.com/issue9/assert/v2"
+)
+
+// connectProxy 测试用的 HTTP CONNECT 代理
+func connectProxy(auth string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		remote, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer remote.Close()
+
+		c, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(remote, c)
+		io.Copy(c, remote)
+	})
+}
+
+// 通过代理隧道连接，相同目标的连接复用
+func Test_HTTPProxyDialer_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		defer c.Close()
+		io.Copy(c, c)
+	}, func(raddr net.Addr) {
+		ps := httptest.NewServer(connectProxy("Basic dXNlcjpwYXNz"))
+		defer ps.Close()
+
+		cp := &ConnPool{
+			Dialer: &HTTPProxyDialer{
+				Address:  ps.Listener.Addr().String(),
+				Username: "user",
+				Password: "pass",
+			},
+			ResolveAddr: RemoteResolveAddr,
+			IdeConn:     5,
+		}
+		defer cp.Close()
+
+		for i := 0; i < 2; i++ {
+			conn, err := cp.Dial("tcp", raddr.String())
+			as.NotError(err)
+			as.Equal(conn.(Conn).IsReuseConn(), i == 1)
+			_, err = conn.Write([]byte("hi"))
+			as.NotError(err)
+			b := make([]byte, 2)
+			_, err = io.ReadFull(conn, b)
+			as.NotError(err).Equal(string(b), "hi")
+			conn.Close()
+		}
+		as.Equal(cp.ConnNum(), 1)
+	})
+}
+
+// 代理认证失败
+func Test_HTTPProxyDialer_2(t *testing.T) {
+	as := assert.New(t, true)
+
+	ps := httptest.NewServer(connectProxy("Basic dXNlcjpwYXNz"))
+	defer ps.Close()
+
+	d := &HTTPProxyDialer{Address: ps.Listener.Addr().String()}
+	_, err := d.Dial("tcp", "127.0.0.1:1")
+	as.Error(err)
+}
+
+// 使用 TLS 连接代理
+func Test_HTTPProxyDialer_3(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		defer c.Close()
+		io.Copy(c, c)
+	}, func(raddr net.Addr) {
+		ps := httptest.NewTLSServer(connectProxy(""))
+		defer ps.Close()
+
+		d := &HTTPProxyDialer{
+			Address:   ps.Listener.Addr().String(),
+			TLSConfig: ps.Client().Transport.(*http.Transport).TLSClientConfig,
+		}
+		conn, err := d.Dial("tcp", raddr.String())
+		as.NotError(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hi"))
+		as.NotError(err)
+		b := make([]byte, 2)
+		_, err = io.ReadFull(conn, b)
+		as.NotError(err).Equal(string(b), "hi")
+	})
+}
@@ -0,0 +1,119 @@
+package vconnpool
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RouteKeyContextKey 上下文的Key，值为 string，是一致性哈希选择后端使用的路由键
+var RouteKeyContextKey = &contextKey{"route-key"}
+
+// hashKey 字符串的哈希值，fnv 的结果再混合一次，使短字符串分布均匀
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ringNode 哈希环的节点
+type ringNode struct {
+	hash    uint64
+	address string
+}
+
+// ringsMax 缓存的哈希环数量上限
+const ringsMax = 16
+
+// consistentHash 哈希环，每个后端有 replicas*Weight 个虚拟节点
+type consistentHash struct {
+	replicas int
+	fallback Balancer
+	mu       sync.Mutex
+	rings    map[string][]ringNode // 按后端的签名缓存哈希环，排除或剔除后端时使用不同的环，最多 ringsMax 个
+}
+
+// build 读取后端的哈希环，没有缓存则创建
+func (T *consistentHash) build(backends []BackendState) []ringNode {
+	var sb strings.Builder
+	for _, b := range backends {
+		sb.WriteString(b.Address)
+		sb.WriteByte('*')
+		sb.WriteString(strconv.Itoa(b.Weight))
+		sb.WriteByte(',')
+	}
+	sign := sb.String()
+
+	T.mu.Lock()
+	defer T.mu.Unlock()
+	if ring, ok := T.rings[sign]; ok {
+		return ring
+	}
+	ring := make([]ringNode, 0, len(backends)*T.replicas)
+	for _, b := range backends {
+		for i := 0; i < T.replicas*b.Weight; i++ {
+			ring = append(ring, ringNode{hash: hashKey(b.Address + "#" + strconv.Itoa(i)), address: b.Address})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	if T.rings == nil {
+		T.rings = make(map[string][]ringNode)
+	}
+	if len(T.rings) >= ringsMax {
+		// 缓存已满，删除任意一个
+		for k := range T.rings {
+			delete(T.rings, k)
+			break
+		}
+	}
+	T.rings[sign] = ring
+	return ring
+}
+
+func (T *consistentHash) Pick(ctx context.Context, backends []BackendState) int {
+	key, ok := ctx.Value(RouteKeyContextKey).(string)
+	if !ok {
+		return T.fallback.Pick(ctx, backends)
+	}
+	ring := T.build(backends)
+	if len(ring) == 0 {
+		return -1
+	}
+	h := hashKey(key)
+	i := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= h
+	})
+	if i == len(ring) {
+		i = 0
+	}
+	for j, b := range backends {
+		if b.Address == ring[i].address {
+			return j
+		}
+	}
+	return -1
+}
+
+// ConsistentHashBalancer 一致性哈希，使用 RouteKeyContextKey 的路由键选择后端，
+// 相同的键选择相同的后端，后端变化时只有少量的键改变后端。
+// 上下文没有路由键，使用 RoundRobinBalancer 选择
+//
+//	replicas int    每个权重的虚拟节点数，小于等于0为160
+//	Balancer        负载均衡
+func ConsistentHashBalancer(replicas int) Balancer {
+	if replicas <= 0 {
+		replicas = 160
+	}
+	return &consistentHash{replicas: replicas, fallback: RoundRobinBalancer()}
+}
@@ -0,0 +1,104 @@
+package vconnpool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/issue9/assert/v2"
+)
+
+func Test_ConsistentHashBalancer(t *testing.T) {
+	as := assert.New(t, true)
+
+	backends := []BackendState{
+		{Backend: Backend{Address: "10.0.0.1:80", Weight: 1}},
+		{Backend: Backend{Address: "10.0.0.2:80", Weight: 1}},
+		{Backend: Backend{Address: "10.0.0.3:80", Weight: 1}},
+	}
+	b := ConsistentHashBalancer(0)
+
+	pick := func(key string, backends []BackendState) string {
+		ctx := context.WithValue(context.Background(), RouteKeyContextKey, key)
+		i := b.Pick(ctx, backends)
+		as.True(i >= 0)
+		return backends[i].Address
+	}
+
+	// 分布均匀，相同的键选择相同的后端
+	picked := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		picked[key] = pick(key, backends)
+		count[picked[key]]++
+		as.Equal(pick(key, backends), picked[key])
+	}
+	for _, n := range count {
+		as.True(n > 700 && n < 1300, n)
+	}
+
+	// 删除一个后端，只有该后端的键改变
+	for key, addr := range picked {
+		got := pick(key, backends[:2])
+		if addr != backends[2].Address {
+			as.Equal(got, addr)
+		}
+	}
+
+	// 没有路由键
+	as.True(b.Pick(context.Background(), backends) >= 0)
+	as.Equal(b.Pick(context.Background(), nil), -1)
+}
+
+// 排除后端交替选择，哈希环使用缓存，不重复创建
+func Test_ConsistentHashBalancer_rings(t *testing.T) {
+	as := assert.New(t, true)
+
+	backends := []BackendState{
+		{Backend: Backend{Address: "10.0.0.1:80", Weight: 1}},
+		{Backend: Backend{Address: "10.0.0.2:80", Weight: 1}},
+		{Backend: Backend{Address: "10.0.0.3:80", Weight: 1}},
+	}
+	b := ConsistentHashBalancer(10).(*consistentHash)
+
+	all := b.build(backends)
+	sub := b.build(backends[:2])
+	as.Equal(len(b.rings), 2)
+	as.True(&b.build(backends)[0] == &all[0])
+	as.True(&b.build(backends[:2])[0] == &sub[0])
+
+	// 缓存数量有上限
+	for i := 0; i < ringsMax*2; i++ {
+		b.build([]BackendState{{Backend: Backend{Address: fmt.Sprintf("10.0.1.%d:80", i), Weight: 1}}})
+	}
+	as.Equal(len(b.rings), ringsMax)
+}
+
+func Test_ConnPool_RouteKey(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 3, func(addrs []string) {
+		cp := &ConnPool{IdeConn: 5}
+		defer cp.Close()
+		var backends []Backend
+		for _, addr := range addrs {
+			backends = append(backends, Backend{Address: addr})
+		}
+		cp.SetService("cache", backends, ConsistentHashBalancer(0))
+
+		ctx := context.WithValue(context.Background(), RouteKeyContextKey, "user:42")
+		conn, err := cp.DialContext(ctx, "tcp", "cache")
+		as.NotError(err)
+		addr := conn.RemoteAddr().String()
+		as.NotError(conn.Close())
+
+		for i := 0; i < 3; i++ {
+			conn, err = cp.DialContext(ctx, "tcp", "cache")
+			as.NotError(err)
+			as.True(conn.(Conn).IsReuseConn())
+			as.Equal(conn.RemoteAddr().String(), addr)
+			as.NotError(conn.Close())
+		}
+	})
+}
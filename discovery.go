@@ -0,0 +1,198 @@
+package vconnpool
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Discovery 服务发现，提供服务的后端
+type Discovery interface {
+	// Watch 读取服务的后端，后端变化时调用 update，直到 ctx 取消
+	Watch(ctx context.Context, update func(backends []Backend)) error
+}
+
+// Discover 使用服务发现更新服务的后端，直到 ctx 取消。
+// 删除的后端，关闭它的空闲连接，使用中的连接关闭后不再回收。
+//
+//	ctx context.Context 上下文
+//	name string         服务名称
+//	d Discovery         服务发现
+//	balancer Balancer   负载均衡，见 SetService
+//	error               错误
+func (T *ConnPool) Discover(ctx context.Context, name string, d Discovery, balancer Balancer) error {
+	return d.Watch(ctx, func(backends []Backend) {
+		T.SetService(name, backends, balancer)
+	})
+}
+
+// StaticDiscovery 固定的后端
+type StaticDiscovery []Backend
+
+// Watch 读取后端，直到 ctx 取消
+func (T StaticDiscovery) Watch(ctx context.Context, update func(backends []Backend)) error {
+	update(T)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// FileDiscovery 从文件读取后端，文件变化后重新读取。
+// 文件内容是后端列表，如 [{"address": "127.0.0.1:80", "weight": 2}]
+type FileDiscovery struct {
+	Path     string          // 文件路径，扩展名是 .yaml 或 .yml 使用 YAML 格式，否则使用 JSON 格式
+	Interval time.Duration   // 检查文件变化的间隔，0为10秒
+	OnError  func(err error) // 读取失败后调用，继续使用之前的后端
+}
+
+// load 读取文件
+func (T *FileDiscovery) load() ([]Backend, error) {
+	data, err := os.ReadFile(T.Path)
+	if err != nil {
+		return nil, err
+	}
+	var backends []Backend
+	switch strings.ToLower(filepath.Ext(T.Path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &backends)
+	default:
+		err = json.Unmarshal(data, &backends)
+	}
+	return backends, err
+}
+
+// Watch 读取后端，文件变化后重新读取，直到 ctx 取消
+func (T *FileDiscovery) Watch(ctx context.Context, update func(backends []Backend)) error {
+	interval := T.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	var modTime time.Time
+	check := func() {
+		fi, err := os.Stat(T.Path)
+		if err == nil && fi.ModTime().Equal(modTime) {
+			return
+		}
+		var backends []Backend
+		if err == nil {
+			backends, err = T.load()
+		}
+		if err != nil {
+			if T.OnError != nil {
+				T.OnError(err)
+			}
+			return
+		}
+		modTime = fi.ModTime()
+		update(backends)
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// DNSResolver DNS 解析，*net.Resolver 实现了该接口
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSDiscovery 从 DNS SRV 记录读取后端，定时重新读取。
+// 使用优先级最高（Priority 最小）的记录，目标解析为 IP 地址，权重为记录的 Weight
+type DNSDiscovery struct {
+	Service  string          // 服务，见 net.LookupSRV
+	Proto    string          // 协议，见 net.LookupSRV
+	Name     string          // 域名，见 net.LookupSRV
+	Resolver DNSResolver     // 解析，为nil 使用 net.DefaultResolver
+	Interval time.Duration   // 重新读取的间隔，0为30秒
+	OnError  func(err error) // 读取失败后调用，继续使用之前的后端
+}
+
+// lookup 读取后端
+func (T *DNSDiscovery) lookup(ctx context.Context) ([]Backend, error) {
+	var resolver DNSResolver = net.DefaultResolver
+	if T.Resolver != nil {
+		resolver = T.Resolver
+	}
+	_, srvs, err := resolver.LookupSRV(ctx, T.Service, T.Proto, T.Name)
+	if err != nil {
+		return nil, err
+	}
+	var backends []Backend
+	for _, srv := range srvs {
+		if srv.Priority != srvs[0].Priority {
+			// 已经按优先级排序
+			break
+		}
+		host := strings.TrimSuffix(srv.Target, ".")
+		addrs, err := resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			backends = append(backends, Backend{
+				Address: net.JoinHostPort(addr, strconv.Itoa(int(srv.Port))),
+				Weight:  int(srv.Weight),
+			})
+		}
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Address < backends[j].Address
+	})
+	return backends, nil
+}
+
+// Watch 读取后端，后端变化后更新，直到 ctx 取消
+func (T *DNSDiscovery) Watch(ctx context.Context, update func(backends []Backend)) error {
+	interval := T.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	var (
+		last   []Backend
+		loaded bool
+	)
+	check := func() {
+		backends, err := T.lookup(ctx)
+		if err != nil {
+			if T.OnError != nil && ctx.Err() == nil {
+				T.OnError(err)
+			}
+			return
+		}
+		if loaded && reflect.DeepEqual(backends, last) {
+			return
+		}
+		last, loaded = backends, true
+		update(backends)
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
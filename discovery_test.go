@@ -0,0 +1,176 @@
+package vconnpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+// waitBackends 等待服务的后端数量
+func waitBackends(cp *ConnPool, name string, n int) []BackendState {
+	for i := 0; i < 200; i++ {
+		if states := cp.ServiceBackends("tcp", name); states != nil && len(states) == n {
+			return states
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cp.ServiceBackends("tcp", name)
+}
+
+func Test_StaticDiscovery(t *testing.T) {
+	as := assert.New(t, true)
+
+	cp := &ConnPool{}
+	defer cp.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- cp.Discover(ctx, "svc", StaticDiscovery{{Address: "127.0.0.1:1"}, {Address: "127.0.0.1:2", Weight: 2}}, nil)
+	}()
+	states := waitBackends(cp, "svc", 2)
+	as.Length(states, 2)
+	as.Equal(states[1].Weight, 2)
+	cancel()
+	as.ErrorIs(<-done, context.Canceled)
+}
+
+func writeBackends(t *testing.T, name, data string, mod time.Time) {
+	if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_FileDiscovery(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 2, func(addrs []string) {
+		cp := &ConnPool{IdeConn: 5}
+		defer cp.Close()
+
+		name := filepath.Join(t.TempDir(), "backends.json")
+		now := time.Now()
+		writeBackends(t, name, `[{"address": "`+addrs[0]+`"}, {"address": "`+addrs[1]+`", "weight": 1}]`, now)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go cp.Discover(ctx, "svc", &FileDiscovery{Path: name, Interval: 10 * time.Millisecond}, LeastActiveBalancer())
+		as.Length(waitBackends(cp, "svc", 2), 2)
+
+		// 两个后端各有一条空闲连接和一条使用中的连接
+		var conns []net.Conn
+		for i := 0; i < 4; i++ {
+			conn, err := cp.Dial("tcp", "svc")
+			as.NotError(err)
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns[:2] {
+			as.NotError(conn.Close())
+		}
+		as.Equal(cp.ConnNumIde("tcp", addrs[0])+cp.ConnNumIde("tcp", addrs[1]), 2)
+
+		// 删除后端，关闭它的空闲连接，使用中的连接不再回收
+		writeBackends(t, name, `[{"address": "`+addrs[1]+`"}]`, now.Add(time.Second))
+		as.Length(waitBackends(cp, "svc", 1), 1)
+		for i := 0; i < 100 && cp.ConnNumIde("tcp", addrs[0]) != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		as.Equal(cp.ConnNumIde("tcp", addrs[0]), 0)
+		for _, conn := range conns[2:] {
+			as.NotError(conn.Close())
+		}
+		as.Equal(cp.ConnNumIde("tcp", addrs[0]), 0)
+		as.Equal(cp.ConnNumIde("tcp", addrs[1]), 2)
+		as.Equal(cp.ConnNum(), 2)
+
+		// 解析失败，继续使用之前的后端
+		errc := make(chan error, 1)
+		bad := filepath.Join(t.TempDir(), "backends.yaml")
+		writeBackends(t, bad, "- address: [", now)
+		go (&FileDiscovery{Path: bad, OnError: func(err error) { errc <- err }}).Watch(ctx, func([]Backend) {})
+		as.Error(<-errc)
+	})
+}
+
+func Test_FileDiscoveryYAML(t *testing.T) {
+	as := assert.New(t, true)
+
+	name := filepath.Join(t.TempDir(), "backends.yml")
+	writeBackends(t, name, "- address: 10.0.0.1:80\n  weight: 2\n- address: 10.0.0.2:80\n", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan []Backend, 1)
+	go (&FileDiscovery{Path: name}).Watch(ctx, func(backends []Backend) { got <- backends })
+	as.Equal(<-got, []Backend{{Address: "10.0.0.1:80", Weight: 2}, {Address: "10.0.0.2:80"}})
+}
+
+type testResolver struct {
+	srvs  chan []*net.SRV
+	hosts map[string][]string
+}
+
+func (T *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "redis" || proto != "tcp" || name != "example.com" {
+		return "", nil, errors.New("no such host")
+	}
+	select {
+	case srvs := <-T.srvs:
+		return "", srvs, nil
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+}
+
+func (T *testResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := T.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func Test_DNSDiscovery(t *testing.T) {
+	as := assert.New(t, true)
+
+	resolver := &testResolver{
+		srvs: make(chan []*net.SRV),
+		hosts: map[string][]string{
+			"a.example.com": {"10.0.0.1"},
+			"b.example.com": {"10.0.0.2", "10.0.0.3"},
+			"c.example.com": {"10.0.0.4"},
+		},
+	}
+	d := &DNSDiscovery{Service: "redis", Proto: "tcp", Name: "example.com", Resolver: resolver, Interval: time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan []Backend)
+	go d.Watch(ctx, func(backends []Backend) { got <- backends })
+
+	// 只使用优先级最高的记录
+	srvs := []*net.SRV{
+		{Target: "a.example.com.", Port: 6379, Priority: 1, Weight: 10},
+		{Target: "b.example.com.", Port: 6380, Priority: 1, Weight: 20},
+		{Target: "c.example.com.", Port: 6379, Priority: 2, Weight: 10},
+	}
+	resolver.srvs <- srvs
+	as.Equal(<-got, []Backend{
+		{Address: "10.0.0.1:6379", Weight: 10},
+		{Address: "10.0.0.2:6380", Weight: 20},
+		{Address: "10.0.0.3:6380", Weight: 20},
+	})
+
+	// 没有变化不更新
+	resolver.srvs <- srvs
+	resolver.srvs <- srvs[:1]
+	as.Equal(<-got, []Backend{{Address: "10.0.0.1:6379", Weight: 10}})
+}
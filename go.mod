@@ -2,3 +2,4 @@ module github.com/456vv/vconnpool/v2
 
 go 1.16
 
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,220 @@
+package vconnpool
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// HedgeDial 对冲拨号，新建连接超过延迟没有完成，再拨号一次，使用先完成的连接。
+// 后完成的连接放入池中，池满则关闭。连接数仍然受 MaxConn 限制
+type HedgeDial struct {
+	Delay        time.Duration // 固定延迟，0为使用该地址观察到的 P95 拨号时间
+	MinDelay     time.Duration // 使用 P95 时的最小延迟，0为不限制
+	Samples      int           // 统计 P95 的拨号次数，0为100。少于10次不对冲拨号
+	OtherBackend bool          // 服务的连接，对冲拨号选择另一个后端，没有则使用同一个后端
+}
+
+func (T *HedgeDial) samples() int {
+	if T.Samples <= 0 {
+		return 100
+	}
+	return T.Samples
+}
+
+// dialLatencyMax 记录拨号时间的地址数量上限
+const dialLatencyMax = 1024
+
+// latencyKey 拨号时间的 key，只区分连接类型和地址，不区分 PROXY 源地址、本地地址和 TLS 配置
+func latencyKey(da *dialArgs) string {
+	return da.network + "," + da.address
+}
+
+// dialLatency 最近的拨号时间
+type dialLatency struct {
+	samples []time.Duration
+	next    int
+}
+
+func (T *dialLatency) add(d time.Duration, max int) {
+	if len(T.samples) < max {
+		T.samples = append(T.samples, d)
+		return
+	}
+	T.samples[T.next%len(T.samples)] = d
+	T.next++
+}
+
+func (T *dialLatency) p95() time.Duration {
+	s := make([]time.Duration, len(T.samples))
+	copy(s, T.samples)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s[len(s)*95/100]
+}
+
+// hedgeDelay 对冲拨号的延迟，小于等于0不对冲拨号
+func (T *ConnPool) hedgeDelay(key string) time.Duration {
+	hd := T.HedgeDial
+	if hd.Delay > 0 {
+		return hd.Delay
+	}
+
+	T.hm.Lock()
+	defer T.hm.Unlock()
+	dl, ok := T.latency[key]
+	if !ok || len(dl.samples) < 10 {
+		return 0
+	}
+	d := dl.p95()
+	if d < hd.MinDelay {
+		d = hd.MinDelay
+	}
+	return d
+}
+
+// hedgeRecord 记录拨号时间
+func (T *ConnPool) hedgeRecord(key string, d time.Duration) {
+	T.hm.Lock()
+	defer T.hm.Unlock()
+	if T.latency == nil {
+		T.latency = make(map[string]*dialLatency)
+	}
+	dl, ok := T.latency[key]
+	if !ok {
+		if len(T.latency) >= dialLatencyMax {
+			// 已满，删除任意一个
+			for k := range T.latency {
+				delete(T.latency, k)
+				break
+			}
+		}
+		dl = new(dialLatency)
+		T.latency[key] = dl
+	}
+	dl.add(d, T.HedgeDial.samples())
+}
+
+// HedgeDials 对冲拨号的次数
+//
+//	started int     开始对冲拨号的次数
+//	won int         对冲拨号先完成的次数
+func (T *ConnPool) HedgeDials() (started, won int) {
+	return int(atomic.LoadInt32(&T.hedgeStarted)), int(atomic.LoadInt32(&T.hedgeWon))
+}
+
+// hedgeArgs 对冲拨号的参数，服务可以选择另一个后端
+func (T *ConnPool) hedgeArgs(ctx context.Context, da *dialArgs) *dialArgs {
+	if da.svc == nil || !T.HedgeDial.OtherBackend {
+		hda := *da
+		return &hda
+	}
+	be, err := da.svc.pick(ctx, T, da.backend)
+	if err != nil {
+		hda := *da
+		return &hda
+	}
+	hda := &dialArgs{network: da.network, address: be.Address, tls: da.tls, serverName: da.serverName, svc: da.svc, backend: be}
+	if err := T.prepare(ctx, hda); err != nil {
+		be.release()
+		return nil
+	}
+	return hda
+}
+
+// dialResult 拨号的结果
+type dialResult struct {
+	conn  net.Conn
+	da    *dialArgs
+	err   error
+	hedge bool
+}
+
+// hedgeDrop 丢弃没有使用的结果，连接放入池中，池满则关闭
+func (T *ConnPool) hedgeDrop(ctx context.Context, r *dialResult, keep *backend) {
+	if r.err == nil {
+		if T.putPoolConn(r.conn, r.da.key, r.da.backend) != nil {
+			atomic.AddInt32(&T.connNum, -1)
+			r.conn.Close()
+		}
+	}
+	if r.da.backend != nil && r.da.backend != keep {
+		// 另一个后端
+		if r.err == nil {
+			r.da.backend.release()
+		} else {
+			T.dialFailed(ctx, r.da, r.err)
+		}
+	}
+}
+
+// dialHedged 新建连接，设置了 HedgeDial 则对冲拨号。返回使用的连接的拨号参数
+func (T *ConnPool) dialHedged(ctx context.Context, da *dialArgs) (net.Conn, *dialArgs, error) {
+	if T.HedgeDial == nil {
+		conn, err := T.dialCtx(ctx, da)
+		return conn, da, err
+	}
+
+	results := make(chan *dialResult, 2)
+	dial := func(da *dialArgs, hedge bool) {
+		start := time.Now()
+		conn, err := T.dialCtx(ctx, da)
+		if err == nil {
+			T.hedgeRecord(latencyKey(da), time.Since(start))
+		}
+		results <- &dialResult{conn: conn, da: da, err: err, hedge: hedge}
+	}
+
+	delay := T.hedgeDelay(latencyKey(da))
+	go dial(da, false)
+	if delay <= 0 {
+		r := <-results
+		return r.conn, r.da, r.err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var (
+		failed  *dialResult
+		pending = 1
+	)
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.hedge {
+					atomic.AddInt32(&T.hedgeWon, 1)
+				}
+				if failed != nil {
+					T.hedgeDrop(ctx, failed, r.da.backend)
+				}
+				if pending != 0 {
+					go func() {
+						T.hedgeDrop(ctx, <-results, r.da.backend)
+					}()
+				}
+				return r.conn, r.da, nil
+			}
+			if failed == nil {
+				failed = r
+			} else {
+				T.hedgeDrop(ctx, r, failed.da.backend)
+			}
+			if pending == 0 {
+				// 都失败了，或第一次拨号在延迟前失败，不对冲拨号
+				return nil, failed.da, failed.err
+			}
+		case <-timer.C:
+			if maxConn := T.maxConn(); maxConn != 0 && int(atomic.LoadInt32(&T.connNum)) >= maxConn {
+				continue
+			}
+			if hda := T.hedgeArgs(ctx, da); hda != nil {
+				atomic.AddInt32(&T.hedgeStarted, 1)
+				pending++
+				go dial(hda, true)
+			}
+		}
+	}
+}
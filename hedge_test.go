@@ -0,0 +1,155 @@
+package vconnpool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/456vv/vconn"
+	"github.com/456vv/x/tcptest"
+
+	"github.com/issue9/assert/v2"
+)
+
+// slowDialer 第一次拨号（或拨号 slow 地址）延迟 300 毫秒
+func slowDialer(slow string) Dialer {
+	var n int32
+	return dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address == slow || slow == "" && atomic.AddInt32(&n, 1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return new(net.Dialer).DialContext(ctx, network, address)
+	})
+}
+
+func waitIdle(cp *ConnPool, address string, n int) int {
+	for i := 0; i < 100 && cp.ConnNumIde("tcp", address) != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cp.ConnNumIde("tcp", address)
+}
+
+func Test_ConnPool_HedgeDial(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{
+			Dialer:    slowDialer(""),
+			IdeConn:   5,
+			HedgeDial: &HedgeDial{Delay: 20 * time.Millisecond},
+		}
+		defer cp.Close()
+
+		start := time.Now()
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.True(time.Since(start) < 200*time.Millisecond)
+		started, won := cp.HedgeDials()
+		as.Equal(started, 1).Equal(won, 1)
+
+		// 后完成的连接放入池中
+		as.Equal(waitIdle(cp, raddr.String(), 1), 1)
+		as.Equal(cp.ConnNum(), 2)
+		as.NotError(conn.Close())
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 2)
+	})
+}
+
+func Test_ConnPool_HedgeDialMaxConn(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{
+			Dialer:    slowDialer(""),
+			IdeConn:   5,
+			MaxConn:   1,
+			HedgeDial: &HedgeDial{Delay: 20 * time.Millisecond},
+		}
+		defer cp.Close()
+
+		// 后完成的连接超出最大连接数，关闭
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		started, won := cp.HedgeDials()
+		as.Equal(started, 1).Equal(won, 1)
+		time.Sleep(400 * time.Millisecond)
+		as.Equal(cp.ConnNum(), 1)
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 0)
+		as.NotError(conn.Close())
+	})
+}
+
+func Test_ConnPool_HedgeDialOtherBackend(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 2, func(addrs []string) {
+		cp := &ConnPool{
+			Dialer:    slowDialer(addrs[0]),
+			IdeConn:   5,
+			HedgeDial: &HedgeDial{Delay: 20 * time.Millisecond, OtherBackend: true},
+		}
+		defer cp.Close()
+		cp.SetService("svc", []Backend{{Address: addrs[0]}, {Address: addrs[1]}}, nil)
+
+		conn, err := cp.Dial("tcp", "svc")
+		as.NotError(err)
+		as.Equal(conn.RemoteAddr().String(), addrs[1])
+
+		// 慢的后端的连接放入池中
+		as.Equal(waitIdle(cp, addrs[0], 1), 1)
+		states := cp.ServiceBackends("tcp", "svc")
+		as.Equal(states[0].Active, 0)
+		as.Equal(states[1].Active, 1)
+		as.NotError(conn.Close())
+		as.Equal(cp.ServiceBackends("tcp", "svc")[1].Active, 0)
+	})
+}
+
+func Test_ConnPool_hedgeDelay(t *testing.T) {
+	as := assert.New(t, true)
+
+	cp := &ConnPool{HedgeDial: &HedgeDial{Samples: 20, MinDelay: 5 * time.Millisecond}}
+	as.Equal(cp.hedgeDelay("k"), time.Duration(0))
+	for i := 1; i <= 20; i++ {
+		cp.hedgeRecord("k", time.Duration(i)*time.Millisecond)
+	}
+	as.Equal(cp.hedgeDelay("k"), 20*time.Millisecond)
+
+	// 只保留最近的拨号时间
+	for i := 0; i < 20; i++ {
+		cp.hedgeRecord("k", time.Millisecond)
+	}
+	as.Equal(cp.hedgeDelay("k"), 5*time.Millisecond)
+}
+
+// 拨号时间按地址记录，数量有上限，关闭空闲连接时清空
+func Test_ConnPool_hedgeLatency(t *testing.T) {
+	as := assert.New(t, true)
+
+	cp := &ConnPool{HedgeDial: &HedgeDial{}}
+	defer cp.Close()
+
+	// PROXY 源地址和本地地址不同，使用同一个记录
+	da1 := &dialArgs{network: "tcp", address: "127.0.0.1:80", key: "tcp,127.0.0.1:80,proxy,10.0.0.1:1000"}
+	da2 := &dialArgs{network: "tcp", address: "127.0.0.1:80", key: "tcp,127.0.0.1:80,proxy,10.0.0.1:1001"}
+	cp.hedgeRecord(latencyKey(da1), time.Millisecond)
+	cp.hedgeRecord(latencyKey(da2), time.Millisecond)
+	as.Equal(len(cp.latency), 1)
+
+	for i := 0; i < dialLatencyMax*2; i++ {
+		cp.hedgeRecord(fmt.Sprintf("tcp,127.0.0.%d:80", i), time.Millisecond)
+	}
+	as.Equal(len(cp.latency), dialLatencyMax)
+
+	cp.CloseIdleConnections()
+	as.Equal(len(cp.latency), 0)
+}
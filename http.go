@@ -0,0 +1,269 @@
+package vconnpool
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// Transport HTTP 传输，拨号由连接池提供。
+// 空闲连接由连接池管理，*http.Transport 不再保留空闲连接，避免重复入池。
+// 协议错误或未完整读取响应的连接，废弃不回收。
+// HTTPS 连接由 ConnPool.DialTLSContext 拨号，使用 TLSClientConfig，
+// 握手超时为 TLSHandshakeTimeout 和 ConnPool.TLSHandshakeTimeout 中较小的。
+type Transport struct {
+	*http.Transport           // HTTP 传输
+	Pool            *ConnPool // 连接池
+}
+
+// NewTransport 创建 HTTP 传输
+//
+//	cp *ConnPool    连接池
+//	*Transport      HTTP 传输
+func NewTransport(cp *ConnPool) *Transport {
+	T := &Transport{Pool: cp}
+	T.Transport = &http.Transport{
+		DialContext:         T.dialContext,
+		DialTLSContext:      T.dialTLSContext,
+		MaxIdleConnsPerHost: -1, // 不保留空闲连接，由连接池回收
+	}
+	return T
+}
+
+func (T *Transport) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := T.Pool.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &httpConn{Conn: conn.(Conn)}, nil
+}
+
+func (T *Transport) dialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if T.TLSHandshakeTimeout != 0 {
+		ctx = context.WithValue(ctx, tlsHandshakeTimeoutContextKey, T.TLSHandshakeTimeout)
+	}
+	conn, err := T.Pool.DialTLSContext(ctx, network, address, T.TLSClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &httpConn{Conn: conn.(Conn)}, nil
+}
+
+// RoundTrip 发送请求
+//
+//	req *http.Request   请求
+//	*http.Response      响应
+//	error               错误
+func (T *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, hc, err := T.roundTrip(req)
+	if err != nil && hc != nil && hc.IsReuseConn() && replayable(req) {
+		// 池中的连接可能已经被远端关闭，使用新连接重试
+		if req.GetBody != nil {
+			body, gerr := req.GetBody()
+			if gerr != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		ctx := context.WithValue(req.Context(), PriorityContextKey, true)
+		resp, _, err = T.roundTrip(req.WithContext(ctx))
+	}
+	return resp, err
+}
+
+func (T *Transport) roundTrip(req *http.Request) (*http.Response, *httpConn, error) {
+	var hc *httpConn
+	gotConn := func(info httptrace.GotConnInfo) httptrace.GotConnInfo {
+		if c, ok := info.Conn.(*httpConn); ok {
+			c.attach()
+			hc = c
+			info.Reused = c.IsReuseConn()
+		}
+		return info
+	}
+
+	ctx := req.Context()
+	if old := httptrace.ContextClientTrace(ctx); old != nil {
+		// 替换用户的跟踪，传递连接池的 Reused
+		trace := *old
+		trace.GotConn = func(info httptrace.GotConnInfo) {
+			info = gotConn(info)
+			if old.GotConn != nil {
+				old.GotConn(info)
+			}
+		}
+		ctx = &traceContext{Context: ctx, old: old, new: &trace}
+	} else {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { gotConn(info) },
+		})
+	}
+
+	resp, err := T.Transport.RoundTrip(req.WithContext(ctx))
+	if hc == nil {
+		return resp, nil, err
+	}
+	if err != nil {
+		hc.finish(false)
+		return nil, hc, err
+	}
+
+	if resp.TLS == nil {
+		// *http.Transport 只识别 *tls.Conn 的连接状态
+		if state := hc.ConnectionState(); state.HandshakeComplete {
+			resp.TLS = &state
+		}
+	}
+
+	reusable := !resp.Close && !req.Close
+	switch {
+	case resp.StatusCode == http.StatusSwitchingProtocols:
+		// 协议升级，连接不再是 HTTP 连接
+		hc.finish(false)
+	case resp.Body == nil || resp.Body == http.NoBody:
+		hc.finish(reusable)
+	default:
+		resp.Body = &httpBody{ReadCloser: resp.Body, conn: hc, reusable: reusable}
+	}
+	return resp, hc, nil
+}
+
+// CloseIdleConnections 关闭空闲连接
+func (T *Transport) CloseIdleConnections() {
+	T.Transport.CloseIdleConnections()
+	T.Pool.CloseIdleConnections()
+}
+
+// replayable 请求是否可以重试
+func replayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// traceContext 替换上下文中的 *httptrace.ClientTrace
+type traceContext struct {
+	context.Context
+	old *httptrace.ClientTrace
+	new *httptrace.ClientTrace
+}
+
+func (T *traceContext) Value(key interface{}) interface{} {
+	v := T.Context.Value(key)
+	if t, ok := v.(*httptrace.ClientTrace); ok && t == T.old {
+		return T.new
+	}
+	return v
+}
+
+// httpConn HTTP 连接，请求结束且 *http.Transport 关闭连接后，才决定是否回收
+type httpConn struct {
+	Conn     // 池中的连接
+	mu       sync.Mutex
+	used     bool // 已经分配给请求
+	closed   bool // *http.Transport 已经关闭连接
+	done     bool // 请求已经结束
+	reusable bool // 请求正常结束，可以回收
+}
+
+// ConnectionState TLS 连接状态，不是 TLS 连接 HandshakeComplete 为 false
+func (T *httpConn) ConnectionState() tls.ConnectionState {
+	if conn, ok := T.Conn.(TLSConn); ok {
+		return conn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+func (T *httpConn) attach() {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+	T.used = true
+}
+
+// finish 请求结束
+func (T *httpConn) finish(reusable bool) {
+	T.mu.Lock()
+	if T.done {
+		T.mu.Unlock()
+		return
+	}
+	T.done = true
+	T.reusable = reusable
+	closed := T.closed
+	T.mu.Unlock()
+
+	if closed {
+		T.release()
+	}
+}
+
+// Close 关闭连接
+//
+//	error   错误
+func (T *httpConn) Close() error {
+	T.mu.Lock()
+	if T.closed {
+		T.mu.Unlock()
+		return errorConnClose
+	}
+	T.closed = true
+	release := !T.used || T.done
+	T.mu.Unlock()
+
+	if release {
+		return T.release()
+	}
+	return nil
+}
+
+func (T *httpConn) release() error {
+	T.mu.Lock()
+	discard := T.used && !T.reusable
+	T.mu.Unlock()
+
+	if discard {
+		T.Conn.Discard()
+	}
+	return T.Conn.Close()
+}
+
+// httpBody 响应主体，读取完整后连接才可以回收
+type httpBody struct {
+	io.ReadCloser
+	conn     *httpConn
+	reusable bool
+	once     sync.Once
+}
+
+func (T *httpBody) Read(p []byte) (n int, err error) {
+	n, err = T.ReadCloser.Read(p)
+	if err == io.EOF {
+		T.finish(T.reusable)
+	} else if err != nil {
+		T.finish(false)
+	}
+	return
+}
+
+func (T *httpBody) Close() error {
+	err := T.ReadCloser.Close()
+	// 未读取完整就关闭，连接不回收
+	T.finish(false)
+	return err
+}
+
+func (T *httpBody) finish(reusable bool) {
+	T.once.Do(func() {
+		T.conn.finish(reusable)
+	})
+}
@@ -0,0 +1,102 @@
+package vconnpool
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/456vv/x/tcptest"
+	"github.com/issue9/assert/v2"
+)
+
+// 读取完整的响应，连接回收到池中，下次请求复用
+func Test_Transport_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+	client := &http.Client{Transport: NewTransport(cp)}
+
+	var reused []bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = append(reused, info.Reused)
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		as.NotError(err)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		resp, err := client.Do(req)
+		as.NotError(err)
+		b, err := io.ReadAll(resp.Body)
+		as.NotError(err).Equal(string(b), "hello")
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+
+		as.Equal(cp.ConnNum(), 1)
+		addr := ts.Listener.Addr()
+		as.Equal(cp.ConnNumIde(addr.Network(), addr.String()), 1)
+	}
+	as.Equal(reused, []bool{false, true})
+}
+
+// 协议错误的连接废弃不回收
+func Test_Transport_2(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		defer c.Close()
+		c.Read(make([]byte, 1024))
+		c.Write([]byte("garbage\r\n\r\n"))
+		time.Sleep(100 * time.Millisecond)
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{IdeConn: 5}
+		defer cp.Close()
+		client := &http.Client{Transport: NewTransport(cp)}
+
+		_, err := client.Get("http://" + raddr.String())
+		as.Error(err)
+		time.Sleep(10 * time.Millisecond)
+
+		as.Equal(cp.ConnNum(), 0)
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 0)
+	})
+}
+
+// 未读取完整就关闭响应，连接不回收
+func Test_Transport_3(t *testing.T) {
+	as := assert.New(t, true)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<20))
+	}))
+	defer ts.Close()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+	client := &http.Client{Transport: NewTransport(cp)}
+
+	resp, err := client.Get(ts.URL)
+	as.NotError(err)
+	resp.Body.Read(make([]byte, 10))
+	resp.Body.Close()
+	// Transport 在后台关闭连接
+	for i := 0; i < 100 && cp.ConnNum() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	as.Equal(cp.ConnNum(), 0)
+	addr := ts.Listener.Addr()
+	as.Equal(cp.ConnNumIde(addr.Network(), addr.String()), 0)
+}
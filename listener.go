@@ -0,0 +1,75 @@
+package vconnpool
+
+import (
+	"net"
+	"time"
+)
+
+// PoolListener 监听器，接受的连接经过识别后自动加入连接池。
+// 服务端可以使用 ConnPool.Get 读取指定远端（如代理ID）的连接。
+// Identify 返回的 addr 和 err 都为nil，表示连接已被接管，不加入池中。
+type PoolListener struct {
+	net.Listener                                           // 监听器
+	Pool             *ConnPool                             // 连接池
+	Identify         func(conn net.Conn) (net.Addr, error) // 识别连接（握手），返回池的 key。为nil 使用 LocalAddr
+	HandshakeTimeout time.Duration                         // 识别超时，0为不超时
+}
+
+// Serve 接受连接并加入连接池，直到监听器关闭
+//
+//	error       错误
+func (T *PoolListener) Serve() error {
+	var tempDelay time.Duration
+	for {
+		conn, err := T.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// 暂时性错误，延迟后重试
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		go T.handle(conn)
+	}
+}
+
+func (T *PoolListener) handle(conn net.Conn) {
+	addr, err := T.identify(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if addr == nil {
+		// 连接已被 Identify 接管
+		return
+	}
+	if err := T.Pool.Put(conn, addr); err != nil {
+		conn.Close()
+	}
+}
+
+// identify 识别连接，返回 addr 和 err 都为nil，表示连接已被接管，不加入池中。
+func (T *PoolListener) identify(conn net.Conn) (net.Addr, error) {
+	if T.Identify == nil {
+		return conn.LocalAddr(), nil
+	}
+	if T.HandshakeTimeout != 0 {
+		conn.SetDeadline(time.Now().Add(T.HandshakeTimeout))
+	}
+	addr, err := T.Identify(conn)
+	if err == nil && addr != nil && T.HandshakeTimeout != 0 {
+		// 清除握手超时，入池后由使用者设置
+		conn.SetDeadline(time.Time{})
+	}
+	return addr, err
+}
@@ -0,0 +1,97 @@
+package vconnpool
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+// 识别后的连接加入池中，可以使用 Get 读取
+func Test_PoolListener_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+
+	cp := &ConnPool{
+		IdeConn: 5,
+	}
+	defer cp.Close()
+
+	pl := &PoolListener{
+		Listener: l,
+		Pool:     cp,
+		Identify: func(conn net.Conn) (net.Addr, error) {
+			id, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			return NewAddr("agent", strings.TrimSpace(id)), nil
+		},
+		HandshakeTimeout: time.Second,
+	}
+	go pl.Serve()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	as.NotError(err)
+	defer c.Close()
+	_, err = c.Write([]byte("a1\n"))
+	as.NotError(err)
+
+	time.Sleep(10 * time.Millisecond)
+	as.Equal(cp.ConnNum(), 1)
+
+	_, err = cp.Get(NewAddr("agent", "a2"))
+	as.ErrorIs(err, ErrConnNotAvailable)
+
+	conn, err := cp.Get(NewAddr("agent", "a1"))
+	as.NotError(err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ok"))
+	as.NotError(err)
+	b := make([]byte, 2)
+	_, err = c.Read(b)
+	as.NotError(err).Equal(string(b), "ok")
+	as.Equal(cp.ConnNum(), 0)
+}
+
+// 识别失败的连接被关闭
+func Test_PoolListener_2(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+
+	cp := &ConnPool{
+		IdeConn: 5,
+	}
+	defer cp.Close()
+
+	pl := &PoolListener{
+		Listener: l,
+		Pool:     cp,
+		Identify: func(conn net.Conn) (net.Addr, error) {
+			_, err := conn.Read(make([]byte, 1))
+			return nil, err
+		},
+		HandshakeTimeout: 10 * time.Millisecond,
+	}
+	go pl.Serve()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	as.NotError(err)
+	defer c.Close()
+
+	// 握手超时，服务端关闭连接
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	as.Error(err)
+	as.Equal(cp.ConnNum(), 0)
+}
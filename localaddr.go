@@ -0,0 +1,60 @@
+package vconnpool
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// LocalAddrPolicy 拨号使用的本地地址（源地址）策略
+type LocalAddrPolicy interface {
+	// LocalAddr 返回连接 network, address 使用的本地地址，返回nil 由系统选择
+	LocalAddr(network, address string) net.Addr
+}
+
+type fixedLocalAddr struct {
+	addr net.Addr
+}
+
+func (T *fixedLocalAddr) LocalAddr(network, address string) net.Addr {
+	return T.addr
+}
+
+// FixedLocalAddr 固定使用一个本地地址
+//
+//	addr net.Addr       本地地址，如 &net.TCPAddr{IP: net.ParseIP("192.168.1.2")}
+//	LocalAddrPolicy     策略
+func FixedLocalAddr(addr net.Addr) LocalAddrPolicy {
+	return &fixedLocalAddr{addr: addr}
+}
+
+type roundRobinLocalAddr struct {
+	addrs []net.Addr
+	next  uint32
+}
+
+func (T *roundRobinLocalAddr) LocalAddr(network, address string) net.Addr {
+	if len(T.addrs) == 0 {
+		return nil
+	}
+	n := atomic.AddUint32(&T.next, 1) - 1
+	return T.addrs[n%uint32(len(T.addrs))]
+}
+
+// RoundRobinLocalAddr 轮流使用多个本地地址，用于分散本地端口
+//
+//	addrs ...net.Addr   本地地址
+//	LocalAddrPolicy     策略
+func RoundRobinLocalAddr(addrs ...net.Addr) LocalAddrPolicy {
+	return &roundRobinLocalAddr{addrs: addrs}
+}
+
+// localDialer 使用本地地址的拨号，*net.Dialer 复制后设置 LocalAddr，
+// 其它拨号通过 LocalAddrContextKey 读取本地地址
+func localDialer(d Dialer, local net.Addr) Dialer {
+	if nd, ok := d.(*net.Dialer); ok {
+		c := *nd
+		c.LocalAddr = local
+		return &c
+	}
+	return d
+}
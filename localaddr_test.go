@@ -0,0 +1,102 @@
+package vconnpool
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/456vv/vconn"
+	"github.com/456vv/x/tcptest"
+
+	"github.com/issue9/assert/v2"
+)
+
+func localIP(conn net.Conn) string {
+	return conn.LocalAddr().(*net.TCPAddr).IP.String()
+}
+
+func Test_ConnPool_LocalAddr(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{
+			IdeConn: 5,
+			LocalAddr: RoundRobinLocalAddr(
+				&net.TCPAddr{IP: net.ParseIP("127.0.0.1")},
+				&net.TCPAddr{IP: net.ParseIP("127.0.0.2")},
+			),
+		}
+		defer cp.Close()
+
+		// 轮流使用本地地址
+		conn1, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.Equal(localIP(conn1), "127.0.0.1")
+		conn2, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.Equal(localIP(conn2), "127.0.0.2")
+		as.NotError(conn1.Close())
+		as.NotError(conn2.Close())
+
+		// 只复用相同本地地址的连接
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.True(conn.(Conn).IsReuseConn())
+		as.Equal(localIP(conn), "127.0.0.1")
+		as.NotError(conn.Close())
+		conn, err = cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.True(conn.(Conn).IsReuseConn())
+		as.Equal(localIP(conn), "127.0.0.2")
+		as.NotError(conn.Close())
+		as.Equal(cp.ConnNum(), 2)
+
+		// 按地址覆盖
+		cp.AddrLocalAddr = map[string]LocalAddrPolicy{
+			raddr.String(): FixedLocalAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.3")}),
+		}
+		conn, err = cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.False(conn.(Conn).IsReuseConn())
+		as.Equal(localIP(conn), "127.0.0.3")
+		as.NotError(conn.Close())
+	})
+}
+
+func Test_ConnPool_LocalAddrContext(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		local := &net.TCPAddr{IP: net.ParseIP("127.0.0.4")}
+		var got net.Addr
+		cp := &ConnPool{
+			Dialer: dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+				got, _ = ctx.Value(LocalAddrContextKey).(net.Addr)
+				return net.Dial(network, address)
+			}),
+			LocalAddr: FixedLocalAddr(local),
+		}
+		defer cp.Close()
+
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.Equal(got, local)
+		as.NotError(conn.Close())
+	})
+}
+
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (T dialerFunc) Dial(network, address string) (net.Conn, error) {
+	return T(context.Background(), network, address)
+}
+
+func (T dialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return T(ctx, network, address)
+}
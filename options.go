@@ -0,0 +1,373 @@
+package vconnpool
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Option 连接池的选项，用于 New
+type Option func(*ConnPool) error
+
+// New 创建连接池，并检查选项。零值的 ConnPool 也可以直接使用
+//
+//	opts ...Option  选项
+//	*ConnPool       连接池
+//	error           错误，选项无效
+func New(opts ...Option) (*ConnPool, error) {
+	cp := new(ConnPool)
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(cp); err != nil {
+			return nil, err
+		}
+	}
+	if err := cp.validate(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// errorOption 选项无效
+func errorOption(name string, format string, args ...interface{}) error {
+	return fmt.Errorf("vconnpool: invalid option %s: %s", name, fmt.Sprintf(format, args...))
+}
+
+// validate 检查配置
+func (T *ConnPool) validate() error {
+	if T.IdeConn < 0 {
+		return errorOption("IdeConn", "%d is negative", T.IdeConn)
+	}
+	if T.MaxConn < 0 {
+		return errorOption("MaxConn", "%d is negative", T.MaxConn)
+	}
+	if T.MaxConn != 0 && T.IdeConn > T.MaxConn {
+		return errorOption("IdeConn", "%d is greater than MaxConn %d", T.IdeConn, T.MaxConn)
+	}
+	if T.TLSSessionCache < 0 {
+		return errorOption("TLSSessionCache", "%d is negative", T.TLSSessionCache)
+	}
+	if T.ProxyProtocol < 0 || T.ProxyProtocol > 2 {
+		return errorOption("ProxyProtocol", "version %d is not 0, 1 or 2", T.ProxyProtocol)
+	}
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"IdeTimeout", T.IdeTimeout},
+		{"TLSHandshakeTimeout", T.TLSHandshakeTimeout},
+		{"ReadTimeout", T.ReadTimeout},
+		{"WriteTimeout", T.WriteTimeout},
+		{"LeaseIdleTimeout", T.LeaseIdleTimeout},
+		{"MaxLease", T.MaxLease},
+	} {
+		if d.d < 0 {
+			return errorOption(d.name, "%v is negative", d.d)
+		}
+	}
+	if T.SocketOptions != nil {
+		if err := T.SocketOptions.validate("SocketOptions"); err != nil {
+			return err
+		}
+	}
+	for address, so := range T.AddrSocketOptions {
+		if so == nil {
+			continue
+		}
+		if err := so.validate("AddrSocketOptions[" + address + "]"); err != nil {
+			return err
+		}
+	}
+	if T.HedgeDial != nil {
+		if err := T.HedgeDial.validate(); err != nil {
+			return err
+		}
+	}
+	if T.SlowStart != nil {
+		if err := T.SlowStart.validate(); err != nil {
+			return err
+		}
+	}
+	if T.OutlierDetection != nil {
+		if err := T.OutlierDetection.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (T *SocketOptions) validate(name string) error {
+	switch {
+	case T.KeepAliveInterval < 0:
+		return errorOption(name+".KeepAliveInterval", "%v is negative", T.KeepAliveInterval)
+	case T.KeepAliveCount < 0:
+		return errorOption(name+".KeepAliveCount", "%d is negative", T.KeepAliveCount)
+	case T.ReadBuffer < 0:
+		return errorOption(name+".ReadBuffer", "%d is negative", T.ReadBuffer)
+	case T.WriteBuffer < 0:
+		return errorOption(name+".WriteBuffer", "%d is negative", T.WriteBuffer)
+	case T.UserTimeout < 0:
+		return errorOption(name+".UserTimeout", "%v is negative", T.UserTimeout)
+	}
+	return nil
+}
+
+func (T *HedgeDial) validate() error {
+	switch {
+	case T.Delay < 0:
+		return errorOption("HedgeDial.Delay", "%v is negative", T.Delay)
+	case T.MinDelay < 0:
+		return errorOption("HedgeDial.MinDelay", "%v is negative", T.MinDelay)
+	case T.Samples < 0:
+		return errorOption("HedgeDial.Samples", "%d is negative", T.Samples)
+	}
+	return nil
+}
+
+func (T *SlowStart) validate() error {
+	switch {
+	case T.Window < 0:
+		return errorOption("SlowStart.Window", "%v is negative", T.Window)
+	case T.MinRatio < 0 || T.MinRatio > 1:
+		return errorOption("SlowStart.MinRatio", "%v is not in [0, 1]", T.MinRatio)
+	case T.DialRate < 0:
+		return errorOption("SlowStart.DialRate", "%v is negative", T.DialRate)
+	}
+	return nil
+}
+
+func (T *OutlierDetection) validate() error {
+	switch {
+	case T.ConsecutiveErrors < 0:
+		return errorOption("OutlierDetection.ConsecutiveErrors", "%d is negative", T.ConsecutiveErrors)
+	case T.ErrorRate < 0 || T.ErrorRate > 1:
+		return errorOption("OutlierDetection.ErrorRate", "%v is not in [0, 1]", T.ErrorRate)
+	case T.MinRequests < 0:
+		return errorOption("OutlierDetection.MinRequests", "%d is negative", T.MinRequests)
+	case T.Interval < 0:
+		return errorOption("OutlierDetection.Interval", "%v is negative", T.Interval)
+	case T.BaseEjectionTime < 0:
+		return errorOption("OutlierDetection.BaseEjectionTime", "%v is negative", T.BaseEjectionTime)
+	case T.MaxEjectionTime < 0:
+		return errorOption("OutlierDetection.MaxEjectionTime", "%v is negative", T.MaxEjectionTime)
+	case T.MaxEjectionPercent < 0 || T.MaxEjectionPercent > 100:
+		return errorOption("OutlierDetection.MaxEjectionPercent", "%d is not in [0, 100]", T.MaxEjectionPercent)
+	}
+	return nil
+}
+
+// WithDialer 拨号
+func WithDialer(d Dialer) Option {
+	return func(cp *ConnPool) error {
+		if d == nil {
+			return errorOption("Dialer", "is nil")
+		}
+		cp.Dialer = d
+		return nil
+	}
+}
+
+// WithResolveAddr 拨号地址解析
+func WithResolveAddr(f func(network, address string) (net.Addr, error)) Option {
+	return func(cp *ConnPool) error {
+		cp.ResolveAddr = f
+		return nil
+	}
+}
+
+// WithIdeConn 空闲连接数
+func WithIdeConn(n int) Option {
+	return func(cp *ConnPool) error {
+		cp.IdeConn = n
+		return nil
+	}
+}
+
+// WithIdeTimeout 空闲自动超时
+func WithIdeTimeout(d time.Duration) Option {
+	return func(cp *ConnPool) error {
+		cp.IdeTimeout = d
+		return nil
+	}
+}
+
+// WithMaxConn 最大连接数
+func WithMaxConn(n int) Option {
+	return func(cp *ConnPool) error {
+		cp.MaxConn = n
+		return nil
+	}
+}
+
+// WithTLSConfig DialTLSContext 的默认 TLS 配置
+func WithTLSConfig(config *tls.Config) Option {
+	return func(cp *ConnPool) error {
+		cp.TLSConfig = config
+		return nil
+	}
+}
+
+// WithTLSHandshakeTimeout TLS 握手超时
+func WithTLSHandshakeTimeout(d time.Duration) Option {
+	return func(cp *ConnPool) error {
+		cp.TLSHandshakeTimeout = d
+		return nil
+	}
+}
+
+// WithTLSSessionCache 每个 key 的 TLS 会话缓存容量
+func WithTLSSessionCache(n int) Option {
+	return func(cp *ConnPool) error {
+		cp.TLSSessionCache = n
+		return nil
+	}
+}
+
+// WithOnDial 新建连接后调用
+func WithOnDial(f func(ctx context.Context, conn net.Conn) error) Option {
+	return func(cp *ConnPool) error {
+		cp.OnDial = f
+		return nil
+	}
+}
+
+// WithReset 连接回收前调用
+func WithReset(f func(conn net.Conn) error) Option {
+	return func(cp *ConnPool) error {
+		cp.Reset = f
+		return nil
+	}
+}
+
+// WithReadTimeout 读出的连接每次读取的超时
+func WithReadTimeout(d time.Duration) Option {
+	return func(cp *ConnPool) error {
+		cp.ReadTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout 读出的连接每次写入的超时
+func WithWriteTimeout(d time.Duration) Option {
+	return func(cp *ConnPool) error {
+		cp.WriteTimeout = d
+		return nil
+	}
+}
+
+// WithLeaseIdleTimeout 读出的连接没有读写超过该时间，强制关闭
+func WithLeaseIdleTimeout(d time.Duration) Option {
+	return func(cp *ConnPool) error {
+		cp.LeaseIdleTimeout = d
+		return nil
+	}
+}
+
+// WithMaxLease 读出的连接最长使用时间
+func WithMaxLease(d time.Duration) Option {
+	return func(cp *ConnPool) error {
+		cp.MaxLease = d
+		return nil
+	}
+}
+
+// WithOnLeaseExpired 租用超时强制关闭连接后调用
+func WithOnLeaseExpired(f func(conn net.Conn)) Option {
+	return func(cp *ConnPool) error {
+		cp.OnLeaseExpired = f
+		return nil
+	}
+}
+
+// WithSocketOptions TCP 连接选项
+func WithSocketOptions(so *SocketOptions) Option {
+	return func(cp *ConnPool) error {
+		cp.SocketOptions = so
+		return nil
+	}
+}
+
+// WithAddrSocketOptions 按地址覆盖 TCP 连接选项
+func WithAddrSocketOptions(address string, so *SocketOptions) Option {
+	return func(cp *ConnPool) error {
+		if cp.AddrSocketOptions == nil {
+			cp.AddrSocketOptions = make(map[string]*SocketOptions)
+		}
+		cp.AddrSocketOptions[address] = so
+		return nil
+	}
+}
+
+// WithLocalAddr 拨号使用的本地地址
+func WithLocalAddr(policy LocalAddrPolicy) Option {
+	return func(cp *ConnPool) error {
+		cp.LocalAddr = policy
+		return nil
+	}
+}
+
+// WithAddrLocalAddr 按地址覆盖拨号使用的本地地址
+func WithAddrLocalAddr(address string, policy LocalAddrPolicy) Option {
+	return func(cp *ConnPool) error {
+		if policy == nil {
+			return errorOption("AddrLocalAddr["+address+"]", "is nil")
+		}
+		if cp.AddrLocalAddr == nil {
+			cp.AddrLocalAddr = make(map[string]LocalAddrPolicy)
+		}
+		cp.AddrLocalAddr[address] = policy
+		return nil
+	}
+}
+
+// WithProxyProtocol 新建连接发送 PROXY 协议头的版本
+func WithProxyProtocol(version int) Option {
+	return func(cp *ConnPool) error {
+		cp.ProxyProtocol = version
+		return nil
+	}
+}
+
+// WithHedgeDial 对冲拨号
+func WithHedgeDial(hd *HedgeDial) Option {
+	return func(cp *ConnPool) error {
+		cp.HedgeDial = hd
+		return nil
+	}
+}
+
+// WithSlowStart 服务后端的慢启动
+func WithSlowStart(ss *SlowStart) Option {
+	return func(cp *ConnPool) error {
+		cp.SlowStart = ss
+		return nil
+	}
+}
+
+// WithOutlierDetection 服务的异常后端剔除
+func WithOutlierDetection(od *OutlierDetection) Option {
+	return func(cp *ConnPool) error {
+		cp.OutlierDetection = od
+		return nil
+	}
+}
+
+// WithService 设置服务，见 SetService
+func WithService(name string, backends []Backend, balancer Balancer) Option {
+	return func(cp *ConnPool) error {
+		if name == "" {
+			return errorOption("Service", "name is empty")
+		}
+		for _, b := range backends {
+			if b.Address == "" {
+				return errorOption("Service["+name+"]", "backend address is empty")
+			}
+		}
+		cp.SetService(name, backends, balancer)
+		return nil
+	}
+}
@@ -0,0 +1,70 @@
+package vconnpool
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+func Test_New(t *testing.T) {
+	as := assert.New(t, true)
+
+	d := new(net.Dialer)
+	cp, err := New(
+		WithDialer(d),
+		WithIdeConn(5),
+		WithMaxConn(10),
+		WithIdeTimeout(time.Minute),
+		WithLeaseIdleTimeout(time.Second),
+		WithMaxLease(time.Minute),
+		WithAddrSocketOptions("127.0.0.1:80", &SocketOptions{KeepAlive: time.Second}),
+		WithService("svc", []Backend{{Address: "127.0.0.1:80"}}, nil),
+	)
+	as.NotError(err)
+	defer cp.Close()
+	as.Equal(cp.Dialer, d)
+	as.Equal(cp.IdeConn, 5).Equal(cp.MaxConn, 10)
+	as.Equal(cp.IdeTimeout, time.Minute)
+	as.Equal(cp.LeaseIdleTimeout, time.Second).Equal(cp.MaxLease, time.Minute)
+	as.Equal(cp.AddrSocketOptions["127.0.0.1:80"].KeepAlive, time.Second)
+	as.Length(cp.ServiceBackends("tcp", "svc"), 1)
+
+	cp, err = New()
+	as.NotError(err)
+	as.NotError(cp.Close())
+}
+
+func Test_New_invalid(t *testing.T) {
+	as := assert.New(t, true)
+
+	for _, test := range []struct {
+		opts []Option
+		name string
+	}{
+		{[]Option{WithDialer(nil)}, "Dialer"},
+		{[]Option{WithIdeConn(-1)}, "IdeConn"},
+		{[]Option{WithMaxConn(-1)}, "MaxConn"},
+		{[]Option{WithIdeConn(5), WithMaxConn(2)}, "IdeConn"},
+		{[]Option{WithIdeTimeout(-time.Second)}, "IdeTimeout"},
+		{[]Option{WithReadTimeout(-time.Second)}, "ReadTimeout"},
+		{[]Option{WithMaxLease(-time.Second)}, "MaxLease"},
+		{[]Option{WithTLSSessionCache(-1)}, "TLSSessionCache"},
+		{[]Option{WithProxyProtocol(3)}, "ProxyProtocol"},
+		{[]Option{WithSocketOptions(&SocketOptions{ReadBuffer: -1})}, "SocketOptions.ReadBuffer"},
+		{[]Option{WithAddrSocketOptions("a:1", &SocketOptions{KeepAliveCount: -1})}, "AddrSocketOptions[a:1].KeepAliveCount"},
+		{[]Option{WithAddrLocalAddr("a:1", nil)}, "AddrLocalAddr[a:1]"},
+		{[]Option{WithHedgeDial(&HedgeDial{Delay: -1})}, "HedgeDial.Delay"},
+		{[]Option{WithSlowStart(&SlowStart{MinRatio: 2})}, "SlowStart.MinRatio"},
+		{[]Option{WithOutlierDetection(&OutlierDetection{ErrorRate: 1.5})}, "OutlierDetection.ErrorRate"},
+		{[]Option{WithOutlierDetection(&OutlierDetection{MaxEjectionPercent: 101})}, "OutlierDetection.MaxEjectionPercent"},
+		{[]Option{WithService("", nil, nil)}, "Service"},
+		{[]Option{WithService("svc", []Backend{{}}, nil)}, "Service[svc]"},
+	} {
+		cp, err := New(test.opts...)
+		as.Error(err, test.name).Nil(cp)
+		as.True(strings.HasPrefix(err.Error(), "vconnpool: invalid option "+test.name+": "), err)
+	}
+}
@@ -0,0 +1,206 @@
+package vconnpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OutlierDetection 异常后端剔除，后端的错误包括拨号失败、连接读写错误和 ReportBackend 报告的健康检查失败。
+// 剔除的后端在剔除时间内不会被选择，并关闭它的空闲连接。
+type OutlierDetection struct {
+	ConsecutiveErrors  int                                   // 连续错误次数达到后剔除，0为不检查
+	ErrorRate          float64                               // 统计周期内错误率（0-1）达到后剔除，0为不检查
+	MinRequests        int                                   // 统计错误率的最少请求数，0为10
+	Interval           time.Duration                         // 错误率的统计周期，0为10秒
+	BaseEjectionTime   time.Duration                         // 剔除时间，每次剔除翻倍，0为30秒
+	MaxEjectionTime    time.Duration                         // 最长剔除时间，0为300秒。恢复后超过该时间没有再剔除，剔除时间重新计算
+	MaxEjectionPercent int                                   // 服务最多剔除后端的百分比，0为10，至少可以剔除1个
+	OnEject            func(address string, d time.Duration) // 剔除后调用
+}
+
+func (T *OutlierDetection) minRequests() int {
+	if T.MinRequests <= 0 {
+		return 10
+	}
+	return T.MinRequests
+}
+
+func (T *OutlierDetection) interval() time.Duration {
+	if T.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return T.Interval
+}
+
+func (T *OutlierDetection) maxEjectionTime() time.Duration {
+	if T.MaxEjectionTime <= 0 {
+		return 300 * time.Second
+	}
+	return T.MaxEjectionTime
+}
+
+// ejectionTime 第 n 次（从0开始）剔除的时间
+func (T *OutlierDetection) ejectionTime(n int) time.Duration {
+	d, max := T.BaseEjectionTime, T.maxEjectionTime()
+	if d <= 0 {
+		d = 30 * time.Second
+	}
+	for ; n > 0 && d < max; n-- {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// maxEjected 服务最多剔除后端的数量
+func (T *OutlierDetection) maxEjected(n int) int {
+	percent := T.MaxEjectionPercent
+	if percent <= 0 {
+		percent = 10
+	}
+	max := n * percent / 100
+	if max < 1 {
+		max = 1
+	}
+	return max
+}
+
+// outlier 后端的错误统计
+type outlier struct {
+	mu          sync.Mutex
+	consecutive int       // 连续错误次数
+	requests    int       // 统计周期内的请求数
+	errors      int       // 统计周期内的错误数
+	windowStart time.Time // 统计周期开始时间
+	ejections   int       // 剔除次数
+}
+
+// roll 统计周期结束，重新统计
+func (T *outlier) roll(now time.Time, od *OutlierDetection) {
+	if now.Sub(T.windowStart) >= od.interval() {
+		T.windowStart = now
+		T.requests, T.errors = 0, 0
+	}
+}
+
+// isEjected 是否在剔除中
+func (T *backend) isEjected(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&T.ejectedUntil)
+}
+
+// success 记录成功的请求
+func (T *backend) success(cp *ConnPool) {
+	od := cp.OutlierDetection
+	if od == nil {
+		return
+	}
+	now := time.Now()
+	T.mu.Lock()
+	defer T.mu.Unlock()
+	T.roll(now, od)
+	T.requests++
+	T.consecutive = 0
+	if T.ejections > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&T.ejectedUntil))) > od.maxEjectionTime() {
+		T.ejections = 0
+	}
+}
+
+// failure 记录错误，达到阈值剔除后端
+func (T *backend) failure(cp *ConnPool) {
+	od := cp.OutlierDetection
+	if od == nil {
+		return
+	}
+	now := time.Now()
+	T.mu.Lock()
+	T.roll(now, od)
+	T.requests++
+	T.errors++
+	T.consecutive++
+
+	eject := od.ConsecutiveErrors > 0 && T.consecutive >= od.ConsecutiveErrors
+	if od.ErrorRate > 0 && T.requests >= od.minRequests() && float64(T.errors)/float64(T.requests) >= od.ErrorRate {
+		eject = true
+	}
+	var d time.Duration
+	if eject && !T.isEjected(now) {
+		d = od.ejectionTime(T.ejections)
+		if T.svc.eject(T, now, d, od) {
+			T.ejections++
+			T.consecutive = 0
+			T.windowStart = now
+			T.requests, T.errors = 0, 0
+		} else {
+			d = 0
+		}
+	}
+	T.mu.Unlock()
+
+	if d != 0 {
+		cp.closeBackendIdle(T)
+		if od.OnEject != nil {
+			od.OnEject(T.Address, d)
+		}
+	}
+}
+
+// eject 剔除后端，超出最多剔除数量返回 false
+func (T *service) eject(b *backend, now time.Time, d time.Duration, od *OutlierDetection) bool {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+
+	n := 0
+	for _, be := range T.backends {
+		if be.isEjected(now) {
+			n++
+		}
+	}
+	if n >= od.maxEjected(len(T.backends)) {
+		return false
+	}
+	atomic.StoreInt64(&b.ejectedUntil, now.Add(d).UnixNano())
+	return true
+}
+
+// closeBackendIdle 关闭后端放入池中的空闲连接，不影响其它服务相同地址的后端
+func (T *ConnPool) closeBackendIdle(be *backend) {
+	T.m.Lock()
+	defer T.m.Unlock()
+	for _, pools := range T.conns {
+		pools.closeFunc(func(cm *connMan) bool {
+			return cm.backend == be
+		})
+	}
+}
+
+// ReportBackend 报告服务后端的健康检查结果，用于异常后端剔除（OutlierDetection）
+//
+//	name string         服务名称
+//	address string      后端地址
+//	healthy bool        是否健康
+func (T *ConnPool) ReportBackend(name, address string, healthy bool) {
+	svc := T.getService(name)
+	if svc == nil {
+		return
+	}
+	svc.mu.RLock()
+	var be *backend
+	for _, b := range svc.backends {
+		if b.Address == address {
+			be = b
+			break
+		}
+	}
+	svc.mu.RUnlock()
+	if be == nil {
+		return
+	}
+	if healthy {
+		be.success(T)
+	} else {
+		be.failure(T)
+	}
+}
@@ -0,0 +1,160 @@
+package vconnpool
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+// closedAddr 没有监听的地址，拨号失败
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func Test_ConnPool_OutlierDetection(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 1, func(addrs []string) {
+		bad := closedAddr(t)
+		ejected := make(chan time.Duration, 4)
+		cp := &ConnPool{
+			IdeConn: 5,
+			OutlierDetection: &OutlierDetection{
+				ConsecutiveErrors:  2,
+				BaseEjectionTime:   300 * time.Millisecond,
+				MaxEjectionPercent: 50,
+				OnEject: func(address string, d time.Duration) {
+					as.Equal(address, bad)
+					ejected <- d
+				},
+			},
+		}
+		defer cp.Close()
+		cp.SetService("svc", []Backend{{Address: bad}, {Address: addrs[0]}}, nil)
+
+		// 连续错误后剔除
+		dial := func() error {
+			conn, err := cp.Dial("tcp", "svc")
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}
+		as.Error(dial())
+		as.NotError(dial())
+		as.Error(dial())
+		as.Equal(<-ejected, 300*time.Millisecond)
+		as.True(cp.ServiceBackends("tcp", "svc")[0].Ejected)
+		for i := 0; i < 5; i++ {
+			as.NotError(dial())
+		}
+
+		// 恢复后再次剔除，剔除时间翻倍
+		time.Sleep(350 * time.Millisecond)
+		as.False(cp.ServiceBackends("tcp", "svc")[0].Ejected)
+		for i := 0; i < 4; i++ {
+			dial()
+		}
+		as.Equal(<-ejected, 600*time.Millisecond)
+
+		// 超过最多剔除百分比，不剔除
+		cp.ReportBackend("svc", addrs[0], false)
+		cp.ReportBackend("svc", addrs[0], false)
+		as.False(cp.ServiceBackends("tcp", "svc")[1].Ejected)
+	})
+}
+
+func Test_ConnPool_OutlierDetectionIdle(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 2, func(addrs []string) {
+		cp := &ConnPool{
+			IdeConn: 5,
+			OutlierDetection: &OutlierDetection{
+				ErrorRate:          0.5,
+				MinRequests:        2,
+				MaxEjectionPercent: 50,
+			},
+		}
+		defer cp.Close()
+		cp.SetService("svc", []Backend{{Address: addrs[0]}, {Address: addrs[1]}}, nil)
+
+		conn, err := cp.Dial("tcp", "svc")
+		as.NotError(err)
+		addr := conn.RemoteAddr().String()
+		as.NotError(conn.Close())
+		as.Equal(cp.ConnNumIde("tcp", addr), 1)
+
+		// 剔除后关闭空闲连接
+		cp.ReportBackend("svc", addr, false)
+		for i := 0; i < 100 && cp.ConnNumIde("tcp", addr) != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		as.Equal(cp.ConnNumIde("tcp", addr), 0)
+		as.Equal(cp.ConnNum(), 0)
+
+		for i := 0; i < 3; i++ {
+			conn, err = cp.Dial("tcp", "svc")
+			as.NotError(err)
+			as.NotEqual(conn.RemoteAddr().String(), addr)
+			as.NotError(conn.Close())
+		}
+	})
+}
+
+// 剔除和删除后端，只关闭该后端的空闲连接，后端地址可以是域名
+func Test_ConnPool_OutlierDetection_closeIdle(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 1, func(addrs []string) {
+		_, port, err := net.SplitHostPort(addrs[0])
+		as.NotError(err)
+		host := net.JoinHostPort("backend.test", port)
+
+		cp := &ConnPool{
+			IdeConn: 5,
+			ResolveAddr: func(network, address string) (net.Addr, error) {
+				if address == host {
+					address = addrs[0]
+				}
+				return ResolveAddr(network, address)
+			},
+			OutlierDetection: &OutlierDetection{
+				ConsecutiveErrors:  1,
+				BaseEjectionTime:   time.Second,
+				MaxEjectionPercent: 100,
+			},
+		}
+		defer cp.Close()
+		cp.SetService("a", []Backend{{Address: host}}, nil)
+		cp.SetService("b", []Backend{{Address: addrs[0]}}, nil)
+
+		conn1, err := cp.Dial("tcp", "a")
+		as.NotError(err)
+		conn2, err := cp.Dial("tcp", "b")
+		as.NotError(err)
+		conn1.Close()
+		conn2.Close()
+		as.Equal(cp.ConnNumIde("tcp", addrs[0]), 2)
+
+		cp.ReportBackend("a", host, false)
+		as.True(cp.ServiceBackends("tcp", "a")[0].Ejected)
+		time.Sleep(10 * time.Millisecond)
+		as.Equal(cp.ConnNumIde("tcp", addrs[0]), 1)
+		as.Equal(cp.ServiceBackends("tcp", "a")[0].Idle, 0)
+		as.Equal(cp.ServiceBackends("tcp", "b")[0].Idle, 1)
+
+		cp.SetService("b", nil, nil)
+		time.Sleep(10 * time.Millisecond)
+		as.Equal(cp.ConnNumIde("tcp", addrs[0]), 0)
+		as.Equal(cp.ConnNum(), 0)
+	})
+}
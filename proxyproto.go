@@ -0,0 +1,98 @@
+package vconnpool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// ProxyHeader PROXY 协议头的地址，使用 ProxyHeaderContextKey 传递给 DialContext。
+// ConnPool.ProxyProtocol 不为0，新建的连接先发送 PROXY 协议头，
+// 池的 key 包含 Source 和 Destination，携带客户端 A 协议头的连接不会给客户端 B 使用。
+type ProxyHeader struct {
+	Source      net.Addr // 客户端地址
+	Destination net.Addr // 目标地址，为nil 使用连接的远程地址
+}
+
+func (T *ProxyHeader) key() string {
+	key := T.Source.String()
+	if T.Destination != nil {
+		key += "," + T.Destination.String()
+	}
+	return key
+}
+
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// addrIPPort 读取地址的 IP 和端口
+func addrIPPort(a net.Addr) (net.IP, int, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, true
+	case *net.UDPAddr:
+		return a.IP, a.Port, true
+	}
+	if a == nil {
+		return nil, 0, false
+	}
+	host, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil, 0, false
+	}
+	return ip, p, true
+}
+
+// writeProxyHeader 写入 PROXY 协议头，地址不是 IP 地址或类型不一致，发送 UNKNOWN (v1) 或 LOCAL (v2)
+func writeProxyHeader(w io.Writer, version int, src, dst net.Addr) error {
+	sip, sport, sok := addrIPPort(src)
+	dip, dport, dok := addrIPPort(dst)
+	known := sok && dok && (sip.To4() == nil) == (dip.To4() == nil)
+
+	switch version {
+	case 1:
+		if !known {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+		proto := "TCP4"
+		if sip.To4() == nil {
+			proto = "TCP6"
+		}
+		_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", proto, sip, dip, sport, dport)
+		return err
+	case 2:
+		b := append([]byte{}, proxySignature...)
+		if !known {
+			// LOCAL 命令，接收方使用连接的真实地址
+			b = append(b, 0x20, 0x00, 0x00, 0x00)
+			_, err := w.Write(b)
+			return err
+		}
+		var addrs []byte
+		if ip4 := sip.To4(); ip4 != nil {
+			b = append(b, 0x21, 0x11) // PROXY, TCP over IPv4
+			addrs = append(append(addrs, ip4...), dip.To4()...)
+		} else {
+			b = append(b, 0x21, 0x21) // PROXY, TCP over IPv6
+			addrs = append(append(addrs, sip.To16()...), dip.To16()...)
+		}
+		ports := make([]byte, 4)
+		binary.BigEndian.PutUint16(ports, uint16(sport))
+		binary.BigEndian.PutUint16(ports[2:], uint16(dport))
+		addrs = append(addrs, ports...)
+
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(addrs)))
+		b = append(append(b, length...), addrs...)
+		_, err := w.Write(b)
+		return err
+	}
+	return fmt.Errorf("vconnpool: unsupported proxy protocol version %d", version)
+}
@@ -0,0 +1,88 @@
+package vconnpool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/456vv/x/tcptest"
+	"github.com/issue9/assert/v2"
+)
+
+// 新建连接发送 PROXY 协议头，不同客户端不复用连接
+func Test_ProxyProtocol_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		defer c.Close()
+		// 回显协议头
+		br := bufio.NewReader(c)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			c.Write([]byte(line))
+		}
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{
+			IdeConn:       5,
+			ProxyProtocol: 1,
+		}
+		defer cp.Close()
+
+		clientA := &ProxyHeader{Source: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}}
+		clientB := &ProxyHeader{Source: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2000}}
+		dial := func(ph *ProxyHeader) Conn {
+			ctx := context.WithValue(context.Background(), ProxyHeaderContextKey, ph)
+			conn, err := cp.DialContext(ctx, raddr.Network(), raddr.String())
+			as.NotError(err)
+			return conn.(Conn)
+		}
+
+		conn := dial(clientA)
+		as.False(conn.IsReuseConn())
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		as.NotError(err)
+		_, port, _ := net.SplitHostPort(raddr.String())
+		as.Equal(line, "PROXY TCP4 10.0.0.1 127.0.0.1 1000 "+port+"\r\n")
+		conn.Close()
+
+		conn = dial(clientB)
+		as.False(conn.IsReuseConn())
+		conn.Close()
+
+		conn = dial(clientA)
+		as.True(conn.IsReuseConn())
+		conn.Close()
+
+		// 没有协议头的连接
+		conn = dial(nil)
+		as.False(conn.IsReuseConn())
+		conn.Close()
+		as.Equal(cp.ConnNum(), 3)
+	})
+}
+
+func Test_writeProxyHeader(t *testing.T) {
+	as := assert.New(t, true)
+
+	src := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	dst := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80}
+
+	var buf bytes.Buffer
+	as.NotError(writeProxyHeader(&buf, 2, src, dst))
+	as.Equal(buf.Bytes(), append(append([]byte{}, proxySignature...),
+		0x21, 0x11, 0x00, 0x0c,
+		10, 0, 0, 1, 10, 0, 0, 2,
+		0x03, 0xe8, 0x00, 0x50,
+	))
+
+	buf.Reset()
+	as.NotError(writeProxyHeader(&buf, 1, src, NewAddr("agent", "a1")))
+	as.Equal(buf.String(), "PROXY UNKNOWN\r\n")
+
+	as.Error(writeProxyHeader(&buf, 3, src, dst))
+}
@@ -0,0 +1,95 @@
+package vconnpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Limits 连接池的限制，用于 Reconfigure
+type Limits struct {
+	IdeConn    int           // 空闲连接数，0为不支持连接入池
+	MaxConn    int           // 最大连接数，0为无限制连接
+	IdeTimeout time.Duration // 空闲自动超时，0为不超时
+}
+
+// poolLimits Reconfigure 设置的限制
+type poolLimits struct {
+	ideConn    int
+	maxConn    int
+	ideTimeout time.Duration
+}
+
+// getLimits 读取 Reconfigure 设置的限制，没有设置返回nil
+func (T *ConnPool) getLimits() *poolLimits {
+	l, _ := T.limits.Load().(*poolLimits)
+	return l
+}
+
+func (T *ConnPool) ideConn() int {
+	if l := T.getLimits(); l != nil {
+		return l.ideConn
+	}
+	return T.IdeConn
+}
+
+func (T *ConnPool) maxConn() int {
+	if l := T.getLimits(); l != nil {
+		return l.maxConn
+	}
+	return T.MaxConn
+}
+
+func (T *ConnPool) ideTimeout() time.Duration {
+	if l := T.getLimits(); l != nil {
+		return l.ideTimeout
+	}
+	return T.IdeTimeout
+}
+
+// Reconfigure 修改连接池的限制，多线程安全。
+// 限制变小，关闭多出的空闲连接；空闲超时改变，池中的空闲连接使用新的超时（从入池的时间开始计算）。
+// 调用后不再使用 IdeConn, MaxConn, IdeTimeout 字段，修改这些字段无效。
+//
+//	l Limits    限制，可以使用 Config.Limits 从配置读取
+//	error       错误，限制无效
+func (T *ConnPool) Reconfigure(l Limits) error {
+	if err := (&ConnPool{IdeConn: l.IdeConn, MaxConn: l.MaxConn, IdeTimeout: l.IdeTimeout}).validate(); err != nil {
+		return err
+	}
+
+	T.init()
+	T.m.Lock()
+	defer T.m.Unlock()
+	T.limits.Store(&poolLimits{ideConn: l.IdeConn, maxConn: l.MaxConn, ideTimeout: l.IdeTimeout})
+
+	// 超出最大连接数，关闭空闲连接
+	excess := 0
+	if l.MaxConn != 0 {
+		excess = int(atomic.LoadInt32(&T.connNum)) - l.MaxConn
+	}
+	for _, ps := range T.conns {
+		excess -= ps.reconfigure(l.IdeConn, l.IdeTimeout, excess)
+	}
+	return nil
+}
+
+// reconfigure 关闭超出 ideConn 的空闲连接，再关闭 excess 条空闲连接，并修改空闲超时。返回关闭的数量
+func (T *pools) reconfigure(ideConn int, ideTimeout time.Duration, excess int) int {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+
+	closed := 0
+	over := len(T.occupy) - ideConn
+	for _, pos := range T.occupy {
+		cm := T.conns[pos]
+		if over > 0 || excess > closed {
+			// notifyYield 负责关闭连接并让位
+			cm.ctxCancel()
+			over--
+			closed++
+			continue
+		}
+		cm.setIdleTimeout(ideTimeout)
+	}
+	return closed
+}
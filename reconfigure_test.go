@@ -0,0 +1,120 @@
+package vconnpool
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/456vv/vconn"
+	"github.com/456vv/x/tcptest"
+
+	"github.com/issue9/assert/v2"
+)
+
+func Test_ConnPool_Reconfigure(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{IdeConn: 5}
+		defer cp.Close()
+
+		dial := func(n int) []net.Conn {
+			var conns []net.Conn
+			for i := 0; i < n; i++ {
+				conn, err := cp.Dial(raddr.Network(), raddr.String())
+				as.NotError(err)
+				conns = append(conns, conn)
+			}
+			return conns
+		}
+		closeAll := func(conns []net.Conn) {
+			for _, conn := range conns {
+				as.NotError(conn.Close())
+			}
+		}
+		closeAll(dial(4))
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 4)
+
+		// 空闲连接数变小，关闭多出的空闲连接
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 2}))
+		as.Equal(waitIdle(cp, raddr.String(), 2), 2)
+		as.Equal(cp.ConnNum(), 2)
+		closeAll(dial(3))
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 2)
+		as.Equal(cp.ConnNum(), 2)
+
+		// 最大连接数变小，关闭空闲连接
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 5}))
+		conns := dial(4)
+		closeAll(conns[:3])
+		as.Equal(cp.ConnNum(), 4)
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 2, MaxConn: 2}))
+		as.Equal(waitIdle(cp, raddr.String(), 1), 1)
+		as.Equal(cp.ConnNum(), 2)
+		_, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		_, err = cp.Dial(raddr.Network(), raddr.String())
+		as.ErrorIs(err, ErrConnPoolMax)
+		closeAll(conns[3:])
+
+		// 池中的空闲连接使用新的空闲超时
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 5, IdeTimeout: 50 * time.Millisecond}))
+		as.True(cp.ConnNumIde(raddr.Network(), raddr.String()) > 0)
+		as.Equal(waitIdle(cp, raddr.String(), 0), 0)
+
+		// 空闲连接数变小后再变大，可以存放更多的空闲连接
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 2}))
+		closeAll(dial(5))
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 2)
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 5}))
+		closeAll(dial(5))
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 5)
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 3}))
+		as.Equal(waitIdle(cp, raddr.String(), 3), 3)
+		closeAll(dial(2))
+		as.Equal(cp.ConnNumIde(raddr.Network(), raddr.String()), 3)
+
+		// 无效的限制
+		as.Error(cp.Reconfigure(Limits{IdeConn: 3, MaxConn: 2}))
+		as.Error(cp.Reconfigure(Limits{IdeTimeout: -1}))
+	})
+}
+
+func Test_ConnPool_ReconfigureRace(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		cp := &ConnPool{IdeConn: 5, MaxConn: 10}
+		defer cp.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if conn, err := cp.Dial(raddr.Network(), raddr.String()); err == nil {
+						conn.Close()
+					}
+				}
+			}()
+		}
+		for i := 0; i < 20; i++ {
+			as.NotError(cp.Reconfigure(Limits{IdeConn: i % 5, MaxConn: 10, IdeTimeout: time.Duration(i) * time.Millisecond}))
+		}
+		wg.Wait()
+		as.NotError(cp.Reconfigure(Limits{}))
+		as.Equal(waitIdle(cp, raddr.String(), 0), 0)
+		for i := 0; i < 100 && cp.ConnNum() != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		as.Equal(cp.ConnNum(), 0)
+	})
+}
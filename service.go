@@ -0,0 +1,343 @@
+package vconnpool
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoBackend 服务没有可用的后端
+var ErrNoBackend = errors.New("vconnpool: the service has no backend available")
+
+// Backend 服务的后端
+type Backend struct {
+	Address string `json:"address" yaml:"address"`                   // 地址，建议使用 IP 地址，host 地址无法统计空闲连接数
+	Weight  int    `json:"weight,omitempty" yaml:"weight,omitempty"` // 权重，小于等于0为1
+}
+
+// BackendState 选择后端时的状态
+type BackendState struct {
+	Backend      // 后端，Weight 已经修正
+	Active  int  // 使用中（包括拨号中）的连接数
+	Idle    int  // 池中空闲连接数，包括所有连接类型和 TLS 配置
+	Ejected bool // 被剔除（OutlierDetection），剔除的后端不会传给 Balancer
+}
+
+// Balancer 负载均衡，从服务的后端中选择一个
+type Balancer interface {
+	// Pick 返回选择的后端下标，小于0为没有可用的后端
+	Pick(ctx context.Context, backends []BackendState) int
+}
+
+// backend 服务的后端，记录连接数
+type backend struct {
+	ejectedUntil int64 // 剔除结束的时间，int64 字段在前面保证 64 位原子操作对齐
+	started      int64 // 加入服务的时间，用于慢启动，服务创建时的后端为0
+	nextDial     int64 // 慢启动期间，下次允许新建连接的时间
+	Backend
+	active  int32      // 使用中的连接数
+	idle    int32      // 池中的空闲连接数
+	removed atomicBool // 已经从服务删除
+	svc     *service   // 所属的服务
+	outlier
+}
+
+// acquire 增加使用中的连接数
+func (T *backend) acquire() {
+	atomic.AddInt32(&T.active, 1)
+}
+
+// release 减少使用中的连接数
+func (T *backend) release() {
+	atomic.AddInt32(&T.active, -1)
+}
+
+// service 服务，名称映射到多个后端
+type service struct {
+	mu       sync.RWMutex
+	balancer Balancer
+	backends []*backend
+}
+
+// set 更新后端，相同地址的后端保留连接数，返回删除的后端
+func (T *service) set(backends []Backend) (removed []*backend) {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+
+	old := make(map[string]*backend, len(T.backends))
+	for _, b := range T.backends {
+		old[b.Address] = b
+	}
+	var started int64
+	if len(T.backends) != 0 {
+		started = time.Now().UnixNano()
+	}
+	bs := make([]*backend, 0, len(backends))
+	for _, b := range backends {
+		if b.Weight <= 0 {
+			b.Weight = 1
+		}
+		be, ok := old[b.Address]
+		if ok {
+			delete(old, b.Address)
+			be.Weight = b.Weight
+		} else {
+			be = &backend{Backend: b, svc: T, started: started}
+		}
+		bs = append(bs, be)
+	}
+	T.backends = bs
+	for _, be := range old {
+		removed = append(removed, be)
+	}
+	return removed
+}
+
+// states 后端的状态和负载均衡，all 为 false 不包括剔除的后端，不包括 exclude
+func (T *service) states(all bool, exclude *backend) ([]*backend, []BackendState, Balancer) {
+	now := time.Now()
+	T.mu.RLock()
+	defer T.mu.RUnlock()
+	bs := make([]*backend, 0, len(T.backends))
+	states := make([]BackendState, 0, len(T.backends))
+	for _, b := range T.backends {
+		ejected := b.isEjected(now)
+		if ejected && !all || b == exclude {
+			continue
+		}
+		bs = append(bs, b)
+		states = append(states, BackendState{
+			Backend: b.Backend,
+			Active:  int(atomic.LoadInt32(&b.active)),
+			Idle:    int(atomic.LoadInt32(&b.idle)),
+			Ejected: ejected,
+		})
+	}
+	return bs, states, T.balancer
+}
+
+// pick 选择后端（除了 exclude），增加该后端使用中的连接数。
+// 慢启动的后端按流量比例拒绝，或限制新建连接，拒绝后在其它后端中重新选择
+func (T *service) pick(ctx context.Context, cp *ConnPool, exclude *backend) (*backend, error) {
+	bs, states, balancer := T.states(false, exclude)
+	ss := cp.SlowStart
+	now := time.Now()
+	for len(bs) != 0 {
+		i := balancer.Pick(ctx, states)
+		if i < 0 || i >= len(bs) {
+			break
+		}
+		be := bs[i]
+		if ss != nil {
+			reject := len(bs) > 1 && !be.admit(ss, now)
+			if !reject && states[i].Idle == 0 && !be.allowDial(ss, now) {
+				reject = true
+			}
+			if reject {
+				bs = append(bs[:i:i], bs[i+1:]...)
+				states = append(states[:i:i], states[i+1:]...)
+				continue
+			}
+		}
+		be.acquire()
+		return be, nil
+	}
+	return nil, ErrNoBackend
+}
+
+func (T *ConnPool) getService(name string) *service {
+	T.sm.RLock()
+	defer T.sm.RUnlock()
+	return T.services[name]
+}
+
+// SetService 设置服务，DialContext(network, name) 使用 balancer 从 backends 选择一个后端拨号，
+// 并复用该后端的空闲连接。服务已经存在则更新后端，相同地址的后端保留连接数。
+// 删除的后端，关闭它放入池中的空闲连接，使用中的连接关闭后不再回收。
+// 服务名称不要和地址相同，DialTLSContext 使用服务时，请设置 tls.Config.ServerName。
+//
+//	name string         服务名称
+//	backends []Backend  后端
+//	balancer Balancer   负载均衡，为nil 服务存在则不变，否则使用 RoundRobinBalancer
+func (T *ConnPool) SetService(name string, backends []Backend, balancer Balancer) {
+	T.sm.Lock()
+	if T.services == nil {
+		T.services = make(map[string]*service)
+	}
+	svc, ok := T.services[name]
+	if !ok {
+		svc = &service{balancer: balancer}
+		if balancer == nil {
+			svc.balancer = RoundRobinBalancer()
+		}
+		T.services[name] = svc
+	} else if balancer != nil {
+		svc.mu.Lock()
+		svc.balancer = balancer
+		svc.mu.Unlock()
+	}
+	removed := svc.set(backends)
+	T.sm.Unlock()
+
+	T.drainBackends(removed)
+}
+
+// RemoveService 删除服务
+//
+//	name string         服务名称
+func (T *ConnPool) RemoveService(name string) {
+	T.sm.Lock()
+	svc, ok := T.services[name]
+	delete(T.services, name)
+	T.sm.Unlock()
+
+	if ok {
+		T.drainBackends(svc.set(nil))
+	}
+}
+
+// drainBackends 删除的后端，关闭空闲连接，使用中的连接关闭后不再回收
+func (T *ConnPool) drainBackends(removed []*backend) {
+	for _, be := range removed {
+		be.removed.setTrue()
+		T.closeBackendIdle(be)
+	}
+}
+
+// ServiceBackends 服务后端的状态，服务不存在返回nil
+//
+//	network string      连接类型，保留参数，空闲连接数包括所有连接类型和 TLS 配置
+//	name string         服务名称
+//	[]BackendState      状态
+func (T *ConnPool) ServiceBackends(network, name string) []BackendState {
+	svc := T.getService(name)
+	if svc == nil {
+		return nil
+	}
+	_, states, _ := svc.states(true, nil)
+	return states
+}
+
+// roundRobin 平滑加权轮询
+type roundRobin struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+func (T *roundRobin) Pick(ctx context.Context, backends []BackendState) int {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+
+	if len(T.current) > len(backends) {
+		// 后端有变化，清除已经删除的后端
+		T.current = nil
+	}
+	if T.current == nil {
+		T.current = make(map[string]int, len(backends))
+	}
+	best, total := -1, 0
+	for i, b := range backends {
+		total += b.Weight
+		T.current[b.Address] += b.Weight
+		if best < 0 || T.current[b.Address] > T.current[backends[best].Address] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		T.current[backends[best].Address] -= total
+	}
+	return best
+}
+
+// RoundRobinBalancer 按权重平滑轮询
+//
+//	Balancer    负载均衡
+func RoundRobinBalancer() Balancer {
+	return &roundRobin{}
+}
+
+type weightedRandom struct{}
+
+func (weightedRandom) Pick(ctx context.Context, backends []BackendState) int {
+	total := 0
+	for _, b := range backends {
+		total += b.Weight
+	}
+	if total <= 0 {
+		return -1
+	}
+	r := rand.Intn(total)
+	for i, b := range backends {
+		if r < b.Weight {
+			return i
+		}
+		r -= b.Weight
+	}
+	return -1
+}
+
+// WeightedRandomBalancer 按权重随机选择
+//
+//	Balancer    负载均衡
+func WeightedRandomBalancer() Balancer {
+	return weightedRandom{}
+}
+
+// lessLoaded a 的负载（使用中的连接数/权重）是否小于 b，相同则比较空闲连接数
+func lessLoaded(a, b BackendState) bool {
+	la, lb := a.Active*b.Weight, b.Active*a.Weight
+	if la != lb {
+		return la < lb
+	}
+	return a.Idle > b.Idle
+}
+
+type leastActive struct{}
+
+func (leastActive) Pick(ctx context.Context, backends []BackendState) int {
+	best := -1
+	for i, b := range backends {
+		if best < 0 || lessLoaded(b, backends[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// LeastActiveBalancer 选择使用中的连接数（按权重）最少的后端，相同则选择空闲连接多的
+//
+//	Balancer    负载均衡
+func LeastActiveBalancer() Balancer {
+	return leastActive{}
+}
+
+type powerOfTwo struct{}
+
+func (powerOfTwo) Pick(ctx context.Context, backends []BackendState) int {
+	switch n := len(backends); n {
+	case 0:
+		return -1
+	case 1:
+		return 0
+	default:
+		a := rand.Intn(n)
+		b := rand.Intn(n - 1)
+		if b >= a {
+			b++
+		}
+		if lessLoaded(backends[b], backends[a]) {
+			return b
+		}
+		return a
+	}
+}
+
+// PowerOfTwoBalancer 随机选择两个后端，使用负载较小的一个
+//
+//	Balancer    负载均衡
+func PowerOfTwoBalancer() Balancer {
+	return powerOfTwo{}
+}
@@ -0,0 +1,174 @@
+package vconnpool
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/456vv/vconn"
+	"github.com/456vv/x/tcptest"
+
+	"github.com/issue9/assert/v2"
+)
+
+func serviceServers(t *testing.T, n int, f func(addrs []string)) {
+	var addrs []string
+	var run func(i int)
+	run = func(i int) {
+		if i == n {
+			f(addrs)
+			return
+		}
+		tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+			<-vconn.New(c).CloseNotify()
+			c.Close()
+		}, func(raddr net.Addr) {
+			addrs = append(addrs, raddr.String())
+			run(i + 1)
+		})
+	}
+	run(0)
+}
+
+func Test_ConnPool_Service(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 2, func(addrs []string) {
+		cp := &ConnPool{IdeConn: 5}
+		defer cp.Close()
+		cp.SetService("svc", []Backend{{Address: addrs[0], Weight: 2}, {Address: addrs[1]}}, nil)
+
+		// 平滑加权轮询
+		var conns []net.Conn
+		for _, want := range []string{addrs[0], addrs[1], addrs[0]} {
+			conn, err := cp.Dial("tcp", "svc")
+			as.NotError(err)
+			as.Equal(conn.RemoteAddr().String(), want)
+			conns = append(conns, conn)
+		}
+		states := cp.ServiceBackends("tcp", "svc")
+		as.Equal(states[0].Active, 2).Equal(states[0].Idle, 0)
+		as.Equal(states[1].Active, 1).Equal(states[1].Idle, 0)
+
+		for _, conn := range conns {
+			as.NotError(conn.Close())
+		}
+		states = cp.ServiceBackends("tcp", "svc")
+		as.Equal(states[0].Active, 0).Equal(states[0].Idle, 2)
+		as.Equal(states[1].Active, 0).Equal(states[1].Idle, 1)
+
+		// 复用选择的后端的空闲连接
+		conn, err := cp.Dial("tcp", "svc")
+		as.NotError(err)
+		as.True(conn.(Conn).IsReuseConn())
+		as.Equal(conn.RemoteAddr().String(), addrs[0])
+		as.NotError(conn.Close())
+
+		// 使用中的连接数少的后端
+		cp.SetService("svc", []Backend{{Address: addrs[0]}, {Address: addrs[1]}}, LeastActiveBalancer())
+		conn1, err := cp.Dial("tcp", "svc")
+		as.NotError(err)
+		conn2, err := cp.Dial("tcp", "svc")
+		as.NotError(err)
+		as.NotEqual(conn1.RemoteAddr().String(), conn2.RemoteAddr().String())
+		as.NotError(conn1.Close())
+		as.NotError(conn2.Close())
+
+		// 没有后端
+		cp.SetService("svc", nil, nil)
+		_, err = cp.Dial("tcp", "svc")
+		as.ErrorIs(err, ErrNoBackend)
+
+		cp.RemoveService("svc")
+		as.Nil(cp.ServiceBackends("tcp", "svc"))
+	})
+}
+
+func Test_Balancer(t *testing.T) {
+	as := assert.New(t, true)
+	ctx := context.Background()
+
+	backends := []BackendState{
+		{Backend: Backend{Address: "a", Weight: 3}, Active: 3},
+		{Backend: Backend{Address: "b", Weight: 1}, Active: 0},
+	}
+
+	count := make([]int, 2)
+	wr := WeightedRandomBalancer()
+	for i := 0; i < 4000; i++ {
+		count[wr.Pick(ctx, backends)]++
+	}
+	as.True(count[0] > 2500 && count[0] < 3500)
+
+	as.Equal(LeastActiveBalancer().Pick(ctx, backends), 1)
+	as.Equal(PowerOfTwoBalancer().Pick(ctx, backends), 1)
+	as.Equal(PowerOfTwoBalancer().Pick(ctx, backends[:1]), 0)
+	as.Equal(RoundRobinBalancer().Pick(ctx, nil), -1)
+
+	// 相同负载，选择空闲连接多的
+	backends[0].Active = 0
+	backends[0].Idle = 2
+	as.Equal(LeastActiveBalancer().Pick(ctx, backends), 0)
+}
+
+// 更新负载均衡和拨号同时进行，空闲连接数随着读出和超时减少
+func Test_ConnPool_Service_concurrent(t *testing.T) {
+	as := assert.New(t, true)
+
+	serviceServers(t, 2, func(addrs []string) {
+		cp := &ConnPool{IdeConn: 5}
+		defer cp.Close()
+		backends := []Backend{{Address: addrs[0]}, {Address: addrs[1]}}
+		cp.SetService("svc", backends, nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					conn, err := cp.Dial("tcp", "svc")
+					as.NotError(err)
+					conn.Close()
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					cp.SetService("svc", backends, LeastActiveBalancer())
+				}
+			}()
+		}
+		wg.Wait()
+
+		idle := func() (n int) {
+			for _, s := range cp.ServiceBackends("tcp", "svc") {
+				n += s.Idle
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		n := idle()
+		as.True(n > 0 && n <= 8)
+		as.Equal(n, cp.ConnNumIde("tcp", addrs[0])+cp.ConnNumIde("tcp", addrs[1]))
+
+		// 让位和超时关闭在后台完成，等待空闲数变化
+		waitIdle := func(m int) int {
+			for i := 0; i < 100 && idle() != m; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return idle()
+		}
+		conn, err := cp.Dial("tcp", "svc")
+		as.NotError(err)
+		as.Equal(waitIdle(n-1), n-1)
+		conn.(Conn).Discard()
+		conn.Close()
+
+		// 空闲超时关闭后，空闲数减少
+		as.NotError(cp.Reconfigure(Limits{IdeConn: 5, IdeTimeout: time.Millisecond}))
+		as.Equal(waitIdle(0), 0)
+	})
+}
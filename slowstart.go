@@ -0,0 +1,68 @@
+package vconnpool
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// SlowStart 慢启动，服务新加入（服务创建时的后端除外）或剔除后恢复的后端，
+// 在 Window 时间内流量逐渐增加，并限制新建连接的速率
+type SlowStart struct {
+	Window   time.Duration // 慢启动的时间，0为不启用
+	MinRatio float64       // 开始时的流量比例（0-1），0为0.1
+	DialRate float64       // 慢启动期间每秒最多新建连接数，0为不限制
+}
+
+// ratio 慢启动开始后经过 elapsed 的流量比例
+func (T *SlowStart) ratio(elapsed time.Duration) float64 {
+	if T.Window <= 0 || elapsed >= T.Window {
+		return 1
+	}
+	min := T.MinRatio
+	if min <= 0 {
+		min = 0.1
+	}
+	r := float64(elapsed) / float64(T.Window)
+	if r < min {
+		r = min
+	}
+	return r
+}
+
+// slowStart 慢启动开始后经过的时间，不在慢启动返回 false
+func (T *backend) slowStart(ss *SlowStart, now time.Time) (time.Duration, bool) {
+	start := atomic.LoadInt64(&T.started)
+	if until := atomic.LoadInt64(&T.ejectedUntil); until > start {
+		// 剔除后恢复
+		start = until
+	}
+	if start == 0 || ss.Window <= 0 {
+		return 0, false
+	}
+	elapsed := now.Sub(time.Unix(0, start))
+	return elapsed, elapsed >= 0 && elapsed < ss.Window
+}
+
+// admit 慢启动的后端，按流量比例随机拒绝
+func (T *backend) admit(ss *SlowStart, now time.Time) bool {
+	elapsed, ok := T.slowStart(ss, now)
+	return !ok || rand.Float64() < ss.ratio(elapsed)
+}
+
+// allowDial 慢启动的后端，限制新建连接的速率
+func (T *backend) allowDial(ss *SlowStart, now time.Time) bool {
+	if _, ok := T.slowStart(ss, now); !ok || ss.DialRate <= 0 {
+		return true
+	}
+	interval := int64(float64(time.Second) / ss.DialRate)
+	for {
+		next := atomic.LoadInt64(&T.nextDial)
+		if now.UnixNano() < next {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&T.nextDial, next, now.UnixNano()+interval) {
+			return true
+		}
+	}
+}
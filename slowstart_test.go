@@ -0,0 +1,84 @@
+package vconnpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+func Test_SlowStart_ratio(t *testing.T) {
+	as := assert.New(t, true)
+
+	ss := &SlowStart{Window: 10 * time.Second}
+	as.Equal(ss.ratio(0), 0.1)
+	as.Equal(ss.ratio(5*time.Second), 0.5)
+	as.Equal(ss.ratio(10*time.Second), 1.0)
+	as.Equal((&SlowStart{}).ratio(0), 1.0)
+}
+
+// pickCount 选择 n 次，统计每个后端被选择的次数
+func pickCount(cp *ConnPool, name string, n int) map[string]int {
+	svc := cp.getService(name)
+	count := make(map[string]int)
+	for i := 0; i < n; i++ {
+		be, err := svc.pick(context.Background(), cp, nil)
+		if err != nil {
+			count[""]++
+			continue
+		}
+		be.release()
+		count[be.Address]++
+	}
+	return count
+}
+
+func Test_ConnPool_SlowStart(t *testing.T) {
+	as := assert.New(t, true)
+
+	cp := &ConnPool{SlowStart: &SlowStart{Window: 5 * time.Second}}
+	defer cp.Close()
+
+	// 服务创建时的后端不慢启动
+	cp.SetService("svc", []Backend{{Address: "10.0.0.1:80"}}, nil)
+	as.Equal(pickCount(cp, "svc", 100)["10.0.0.1:80"], 100)
+
+	// 新加入的后端流量逐渐增加
+	cp.SetService("svc", []Backend{{Address: "10.0.0.1:80"}, {Address: "10.0.0.2:80"}}, nil)
+	count := pickCount(cp, "svc", 2000)
+	as.True(count["10.0.0.2:80"] > 0 && count["10.0.0.2:80"] < 400, count)
+
+	// 慢启动结束
+	svc := cp.getService("svc")
+	be := svc.backends[1]
+	atomic.StoreInt64(&be.started, time.Now().Add(-5*time.Second).UnixNano())
+	count = pickCount(cp, "svc", 2000)
+	as.Equal(count["10.0.0.2:80"], 1000)
+
+	// 剔除后恢复，重新慢启动
+	atomic.StoreInt64(&be.ejectedUntil, time.Now().UnixNano())
+	count = pickCount(cp, "svc", 2000)
+	as.True(count["10.0.0.2:80"] < 400, count)
+}
+
+func Test_ConnPool_SlowStartDialRate(t *testing.T) {
+	as := assert.New(t, true)
+
+	cp := &ConnPool{SlowStart: &SlowStart{Window: 5 * time.Second, MinRatio: 1, DialRate: 0.5}}
+	defer cp.Close()
+
+	cp.SetService("svc", []Backend{{Address: "10.0.0.1:80"}}, nil)
+	cp.SetService("svc", []Backend{{Address: "10.0.0.1:80"}, {Address: "10.0.0.2:80"}}, nil)
+
+	// 每2秒只允许新建一条连接
+	count := pickCount(cp, "svc", 10)
+	as.Equal(count["10.0.0.2:80"], 1)
+	as.Equal(count["10.0.0.1:80"], 9)
+
+	// 只有慢启动的后端，超出速率没有可用的后端
+	cp.SetService("svc", []Backend{{Address: "10.0.0.2:80"}}, nil)
+	count = pickCount(cp, "svc", 2)
+	as.Equal(count[""], 2)
+}
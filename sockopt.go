@@ -0,0 +1,59 @@
+package vconnpool
+
+import (
+	"net"
+	"time"
+)
+
+// SocketOptions TCP 连接的选项，零值为不修改
+type SocketOptions struct {
+	KeepAlive         time.Duration // keepalive 空闲时间，0为不修改，小于0为关闭 keepalive
+	KeepAliveInterval time.Duration // keepalive 探测间隔，0为不修改（仅 linux）
+	KeepAliveCount    int           // keepalive 探测次数，0为不修改（仅 linux）
+	NoDelay           *bool         // TCP_NODELAY，nil为不修改
+	Linger            *int          // SO_LINGER（秒），nil为不修改
+	ReadBuffer        int           // 接收缓冲区大小，0为不修改
+	WriteBuffer       int           // 发送缓冲区大小，0为不修改
+	UserTimeout       time.Duration // TCP_USER_TIMEOUT，0为不修改（仅 linux）
+}
+
+// apply 设置连接的选项，不是 TCP 连接跳过
+func (T *SocketOptions) apply(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if T.KeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if T.KeepAlive > 0 {
+		if err := tc.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tc.SetKeepAlivePeriod(T.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if T.NoDelay != nil {
+		if err := tc.SetNoDelay(*T.NoDelay); err != nil {
+			return err
+		}
+	}
+	if T.Linger != nil {
+		if err := tc.SetLinger(*T.Linger); err != nil {
+			return err
+		}
+	}
+	if T.ReadBuffer != 0 {
+		if err := tc.SetReadBuffer(T.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if T.WriteBuffer != 0 {
+		if err := tc.SetWriteBuffer(T.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return T.applyPlatform(tc)
+}
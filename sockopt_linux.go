@@ -0,0 +1,41 @@
+package vconnpool
+
+import (
+	"net"
+	"syscall"
+)
+
+// tcpUserTimeout TCP_USER_TIMEOUT，syscall 包没有定义
+const tcpUserTimeout = 0x12
+
+// applyPlatform 设置 linux 支持的选项
+func (T *SocketOptions) applyPlatform(tc *net.TCPConn) error {
+	if T.KeepAliveInterval == 0 && T.KeepAliveCount == 0 && T.UserTimeout == 0 {
+		return nil
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if T.KeepAliveInterval > 0 {
+			secs := int((T.KeepAliveInterval + 999999999) / 1e9) // 向上取整到秒
+			if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); serr != nil {
+				return
+			}
+		}
+		if T.KeepAliveCount > 0 {
+			if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, T.KeepAliveCount); serr != nil {
+				return
+			}
+		}
+		if T.UserTimeout > 0 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(T.UserTimeout.Milliseconds()))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
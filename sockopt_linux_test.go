@@ -0,0 +1,80 @@
+package vconnpool
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/456vv/vconn"
+	"github.com/456vv/x/tcptest"
+
+	"github.com/issue9/assert/v2"
+)
+
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+func Test_ConnPool_SocketOptions(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		<-vconn.New(c).CloseNotify()
+		c.Close()
+	}, func(raddr net.Addr) {
+		noDelay := false
+		cp := &ConnPool{
+			IdeConn: 5,
+			SocketOptions: &SocketOptions{
+				KeepAlive:         20 * time.Second,
+				KeepAliveInterval: 5 * time.Second,
+				KeepAliveCount:    3,
+				NoDelay:           &noDelay,
+				UserTimeout:       7 * time.Second,
+			},
+		}
+		defer cp.Close()
+
+		conn, err := cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		rc := conn.(Conn).RawConn()
+		as.Equal(getsockopt(t, rc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), 1)
+		as.Equal(getsockopt(t, rc, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE), 20)
+		as.Equal(getsockopt(t, rc, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL), 5)
+		as.Equal(getsockopt(t, rc, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT), 3)
+		as.Equal(getsockopt(t, rc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY), 0)
+		as.Equal(getsockopt(t, rc, syscall.IPPROTO_TCP, tcpUserTimeout), 7000)
+		rc.Close()
+
+		// 按地址覆盖
+		cp.AddrSocketOptions = map[string]*SocketOptions{
+			raddr.String(): {KeepAlive: -1},
+		}
+		conn, err = cp.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		rc = conn.(Conn).RawConn()
+		as.Equal(getsockopt(t, rc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), 0)
+		as.Equal(getsockopt(t, rc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY), 1)
+		rc.Close()
+
+		// Put 的连接
+		c, err := net.Dial(raddr.Network(), raddr.String())
+		as.NotError(err)
+		as.NotError(cp.Put(c, raddr))
+		as.Equal(getsockopt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), 0)
+	})
+}
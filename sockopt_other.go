@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package vconnpool
+
+import "net"
+
+// applyPlatform 不支持 KeepAliveInterval, KeepAliveCount, UserTimeout，跳过
+func (T *SocketOptions) applyPlatform(tc *net.TCPConn) error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package vconnpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+	socks5Connect      = 0x01
+	socks5AddrIPv4     = 0x01
+	socks5AddrDomain   = 0x03
+	socks5AddrIPv6     = 0x04
+)
+
+var errorSOCKS5Auth = errors.New("vconnpool: socks5 authentication failed")
+
+// SOCKS5Dialer SOCKS5 代理拨号，支持无认证和用户名/密码认证，可以设置为 ConnPool.Dialer 使用。
+// 池的 key 是目标地址，不是代理地址。
+// ConnPool.ResolveAddr 设置为 RemoteResolveAddr，域名不在本地解析，由代理解析。
+type SOCKS5Dialer struct {
+	Dialer   Dialer // 连接代理的拨号，为nil 使用 net.Dialer
+	Network  string // 代理连接类型，为空使用 tcp
+	Address  string // 代理地址
+	Username string // 用户名，为空不认证
+	Password string // 密码
+}
+
+// Dial 见 DialContext
+//
+//	network string      连接类型
+//	address string      目标地址
+//	net.Conn            连接
+//	error               错误
+func (T *SOCKS5Dialer) Dial(network, address string) (net.Conn, error) {
+	return T.DialContext(context.Background(), network, address)
+}
+
+// DialContext 通过代理连接目标地址
+//
+//	ctx context.Context 上下文
+//	network string      连接类型，仅支持 tcp, tcp4, tcp6
+//	address string      目标地址，支持域名
+//	net.Conn            连接
+//	error               错误
+func (T *SOCKS5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("the network type %s not support", network)
+	}
+
+	dialer := T.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	proxyNetwork := T.Network
+	if proxyNetwork == "" {
+		proxyNetwork = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, proxyNetwork, T.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	if err = T.connect(conn, address); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+// connect 握手并请求连接目标地址
+func (T *SOCKS5Dialer) connect(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return fmt.Errorf("vconnpool: socks5 invalid port %s", portStr)
+	}
+
+	// 认证方法
+	methods := []byte{socks5AuthNone}
+	if T.Username != "" {
+		methods = []byte{socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err = conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	b := make([]byte, 2)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return fmt.Errorf("vconnpool: socks5 unexpected version %d", b[0])
+	}
+	switch b[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if T.Username == "" || len(T.Username) > 255 || len(T.Password) > 255 {
+			return errorSOCKS5Auth
+		}
+		req := []byte{0x01, byte(len(T.Username))}
+		req = append(req, T.Username...)
+		req = append(req, byte(len(T.Password)))
+		req = append(req, T.Password...)
+		if _, err = conn.Write(req); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		if b[1] != 0x00 {
+			return errorSOCKS5Auth
+		}
+	default:
+		return errorSOCKS5Auth
+	}
+
+	// 连接请求
+	req := []byte{socks5Version, socks5Connect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("vconnpool: socks5 host name too long %s", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	// 应答
+	b = make([]byte, 4)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[1] != 0x00 {
+		return fmt.Errorf("vconnpool: socks5 connect %s failed, reply code %d", address, b[1])
+	}
+	var n int
+	switch b[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		if _, err = io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("vconnpool: socks5 unknown address type %d", b[3])
+	}
+	// 绑定地址和端口
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
@@ -0,0 +1,140 @@
+package vconnpool
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/456vv/x/tcptest"
+	"github.com/issue9/assert/v2"
+)
+
+// socks5Server 测试用的 SOCKS5 代理，记录请求的目标地址
+type socks5Server struct {
+	username string
+	password string
+	mu       sync.Mutex
+	targets  []string
+}
+
+func (T *socks5Server) serve(c net.Conn) {
+	defer c.Close()
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return
+	}
+	methods := make([]byte, b[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+
+	if T.username != "" {
+		c.Write([]byte{socks5Version, socks5AuthPassword})
+		io.ReadFull(c, b)
+		user := make([]byte, b[1])
+		io.ReadFull(c, user)
+		io.ReadFull(c, b[:1])
+		pass := make([]byte, b[0])
+		io.ReadFull(c, pass)
+		if string(user) != T.username || string(pass) != T.password {
+			c.Write([]byte{0x01, 0x01})
+			return
+		}
+		c.Write([]byte{0x01, 0x00})
+	} else {
+		c.Write([]byte{socks5Version, socks5AuthNone})
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(c, head); err != nil {
+		return
+	}
+	var host string
+	switch head[3] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		io.ReadFull(c, ip)
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		io.ReadFull(c, b[:1])
+		name := make([]byte, b[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	}
+	io.ReadFull(c, b)
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b))))
+
+	T.mu.Lock()
+	T.targets = append(T.targets, target)
+	T.mu.Unlock()
+
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer remote.Close()
+	c.Write([]byte{socks5Version, 0x00, 0x00, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+
+	go io.Copy(remote, c)
+	io.Copy(c, remote)
+}
+
+// 通过代理连接，池的 key 是目标地址，域名由代理解析
+func Test_SOCKS5Dialer_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	tcptest.D2S("127.0.0.1:0", func(c net.Conn) {
+		defer c.Close()
+		io.Copy(c, c)
+	}, func(raddr net.Addr) {
+		proxy := &socks5Server{username: "user", password: "pass"}
+		tcptest.D2S("127.0.0.1:0", proxy.serve, func(paddr net.Addr) {
+			_, port, _ := net.SplitHostPort(raddr.String())
+			target := net.JoinHostPort("localhost", port)
+
+			cp := &ConnPool{
+				Dialer:      &SOCKS5Dialer{Address: paddr.String(), Username: "user", Password: "pass"},
+				ResolveAddr: RemoteResolveAddr,
+				IdeConn:     5,
+			}
+			defer cp.Close()
+
+			for i := 0; i < 2; i++ {
+				conn, err := cp.Dial("tcp", target)
+				as.NotError(err)
+				as.Equal(conn.(Conn).IsReuseConn(), i == 1)
+				_, err = conn.Write([]byte("hi"))
+				as.NotError(err)
+				b := make([]byte, 2)
+				_, err = io.ReadFull(conn, b)
+				as.NotError(err).Equal(string(b), "hi")
+				conn.Close()
+			}
+			as.Equal(cp.ConnNumIde("tcp", target), 1)
+
+			proxy.mu.Lock()
+			as.Equal(proxy.targets, []string{target})
+			proxy.mu.Unlock()
+		})
+	})
+}
+
+// 认证失败
+func Test_SOCKS5Dialer_2(t *testing.T) {
+	as := assert.New(t, true)
+
+	proxy := &socks5Server{username: "user", password: "pass"}
+	tcptest.D2S("127.0.0.1:0", proxy.serve, func(paddr net.Addr) {
+		d := &SOCKS5Dialer{Address: paddr.String(), Username: "user", Password: "bad"}
+		_, err := d.Dial("tcp", "127.0.0.1:1")
+		as.ErrorIs(err, errorSOCKS5Auth)
+
+		d = &SOCKS5Dialer{Address: paddr.String()}
+		_, err = d.Dial("tcp", "127.0.0.1:1")
+		as.ErrorIs(err, errorSOCKS5Auth)
+	})
+}
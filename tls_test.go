@@ -0,0 +1,312 @@
+package vconnpool
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+// tlsTestCertificates 测试用的服务端证书
+func tlsTestCertificates(t *testing.T) []tls.Certificate {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ts.Close()
+	return ts.TLS.Certificates
+}
+
+// tlsListen 启动 TLS 回显服务器
+func tlsListen(t *testing.T, config *tls.Config) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func tlsEchoServer(t *testing.T) (net.Listener, *tls.Config) {
+	l := tlsListen(t, &tls.Config{Certificates: tlsTestCertificates(t)})
+	return l, &tls.Config{InsecureSkipVerify: true}
+}
+
+// TLS 连接回收后复用，TCP 连接和不同配置的 TLS 连接不会混用
+func Test_ConnPool_TLS_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, config := tlsEchoServer(t)
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{
+		IdeConn:             5,
+		TLSHandshakeTimeout: time.Second,
+	}
+	defer cp.Close()
+
+	conn, err := cp.DialTLS(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	c := conn.(Conn)
+	as.False(c.IsReuseConn())
+	as.True(conn.(TLSConn).ConnectionState().HandshakeComplete)
+	conn.Close()
+
+	conn, err = cp.DialTLS(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	c = conn.(Conn)
+	as.True(c.IsReuseConn())
+	as.True(conn.(TLSConn).ConnectionState().HandshakeComplete)
+
+	_, err = c.Write([]byte("tls"))
+	as.NotError(err)
+	b := make([]byte, 3)
+	_, err = io.ReadFull(c, b)
+	as.NotError(err).Equal(string(b), "tls")
+	conn.Close()
+
+	// 相同内容的配置，复用连接
+	conn, err = cp.DialTLS(raddr.Network(), raddr.String(), config.Clone())
+	as.NotError(err)
+	as.True(conn.(Conn).IsReuseConn())
+	conn.Close()
+
+	// 不同配置，新建连接
+	other := config.Clone()
+	other.MinVersion = tls.VersionTLS12
+	conn, err = cp.DialTLS(raddr.Network(), raddr.String(), other)
+	as.NotError(err)
+	as.False(conn.(Conn).IsReuseConn())
+	conn.Close()
+
+	// TCP 连接不会读取到 TLS 连接
+	conn, err = cp.Dial(raddr.Network(), raddr.String())
+	as.NotError(err)
+	as.False(conn.(Conn).IsReuseConn())
+	as.False(conn.(TLSConn).ConnectionState().HandshakeComplete)
+	conn.(Conn).Discard()
+	conn.Close()
+}
+
+// 握手失败，连接数不增加
+func Test_ConnPool_TLS_2(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, _ := tlsEchoServer(t)
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+
+	// 证书验证失败
+	_, err := cp.DialTLS(raddr.Network(), raddr.String(), &tls.Config{})
+	as.Error(err)
+	as.Equal(cp.ConnNum(), 0)
+}
+
+// HTTPS 连接回收复用
+func Test_Transport_TLS(t *testing.T) {
+	as := assert.New(t, true)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+	tr := NewTransport(cp)
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		as.NotError(err)
+		as.NotNil(resp.TLS)
+		b, err := io.ReadAll(resp.Body)
+		as.NotError(err).Equal(string(b), "hello")
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+		as.Equal(cp.ConnNum(), 1)
+	}
+}
+
+// 新建的 TLS 连接使用会话缓存恢复
+func Test_ConnPool_TLS_3(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, config := tlsEchoServer(t)
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{
+		IdeConn:         5,
+		TLSSessionCache: 8,
+	}
+	defer cp.Close()
+
+	conn, err := cp.DialTLS(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	as.False(conn.(TLSConn).ConnectionState().DidResume)
+	_, err = conn.Write([]byte("tls"))
+	as.NotError(err)
+	_, err = io.ReadFull(conn, make([]byte, 3))
+	as.NotError(err)
+	conn.(Conn).Discard()
+	conn.Close()
+
+	conn, err = cp.DialTLS(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	as.False(conn.(Conn).IsReuseConn())
+	as.True(conn.(TLSConn).ConnectionState().DidResume)
+	conn.Close()
+
+	full, resumed := cp.TLSHandshakes()
+	as.Equal(full, 1).Equal(resumed, 1)
+}
+
+// 配置有回调函数，不同的 *tls.Config 不会混用
+func Test_ConnPool_TLS_callback(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, config := tlsEchoServer(t)
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+
+	newConfig := func() *tls.Config {
+		c := config.Clone()
+		c.VerifyConnection = func(tls.ConnectionState) error { return nil }
+		return c
+	}
+	config1 := newConfig()
+	conn, err := cp.DialTLS(raddr.Network(), raddr.String(), config1)
+	as.NotError(err)
+	conn.Close()
+
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		conn, err = cp.DialTLS(raddr.Network(), raddr.String(), newConfig())
+		as.NotError(err)
+		as.False(conn.(Conn).IsReuseConn())
+		conn.(Conn).Discard()
+		conn.Close()
+	}
+
+	conn, err = cp.DialTLS(raddr.Network(), raddr.String(), config1)
+	as.NotError(err)
+	as.True(conn.(Conn).IsReuseConn())
+	conn.Close()
+}
+
+// Put 的 TLS 连接不会被 TCP 拨号读取到，Get 可以读取
+func Test_ConnPool_TLS_put(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, config := tlsEchoServer(t)
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+
+	tc, err := tls.Dial(raddr.Network(), raddr.String(), config)
+	as.NotError(err)
+	as.NotError(cp.Add(tc))
+
+	conn, err := cp.Dial(raddr.Network(), raddr.String())
+	as.NotError(err)
+	as.False(conn.(Conn).IsReuseConn())
+	as.False(conn.(TLSConn).ConnectionState().HandshakeComplete)
+	conn.(Conn).Discard()
+	conn.Close()
+
+	conn, err = cp.Get(raddr)
+	as.NotError(err)
+	as.True(conn.(TLSConn).ConnectionState().HandshakeComplete)
+	conn.Close()
+}
+
+// Transport.TLSHandshakeTimeout 用于连接池的握手
+func Test_Transport_TLSHandshakeTimeout(t *testing.T) {
+	as := assert.New(t, true)
+
+	// 不响应握手
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cp := &ConnPool{IdeConn: 5}
+	defer cp.Close()
+	tr := NewTransport(cp)
+	tr.TLSHandshakeTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err = (&http.Client{Transport: tr}).Get("https://" + l.Addr().String())
+	as.Error(err)
+	as.True(time.Since(start) < time.Second)
+	as.Equal(cp.ConnNum(), 0)
+}
+
+// 关闭空闲连接清空会话缓存，会话缓存的 key 数量有上限
+func Test_ConnPool_TLS_sessions(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, config := tlsEchoServer(t)
+	defer l.Close()
+	raddr := l.Addr()
+
+	cp := &ConnPool{IdeConn: 5, TLSSessionCache: 8}
+	defer cp.Close()
+
+	dial := func() {
+		conn, err := cp.DialTLS(raddr.Network(), raddr.String(), config)
+		as.NotError(err)
+		_, err = conn.Write([]byte("tls"))
+		as.NotError(err)
+		_, err = io.ReadFull(conn, make([]byte, 3))
+		as.NotError(err)
+		conn.(Conn).Discard()
+		conn.Close()
+	}
+	dial()
+	dial()
+	cp.CloseIdleConnections()
+	dial()
+	full, resumed := cp.TLSHandshakes()
+	as.Equal(full, 2).Equal(resumed, 1)
+
+	for i := 0; i < tlsSessionsMax+10; i++ {
+		cp.sessionCache(strconv.Itoa(i))
+	}
+	as.Equal(len(cp.sessions), tlsSessionsMax)
+}
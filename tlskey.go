@@ -0,0 +1,93 @@
+package vconnpool
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// tlsRegistryMax 登记的对象数量上限，超出后删除最早登记的
+const tlsRegistryMax = 1024
+
+// tlsRegistry 给不能比较内容的对象（如 *x509.CertPool、带回调函数的 *tls.Config）分配编号。
+// 登记时保存对象的引用，对象不会被回收，所以不同的对象不会得到相同的编号。
+// 删除后再次登记得到新的编号，只是不再复用旧的连接。
+type tlsRegistry struct {
+	mu    sync.Mutex
+	ids   map[interface{}]uint64
+	order []interface{}
+	next  uint64
+}
+
+// id 读取对象的编号，没有则登记
+func (T *tlsRegistry) id(v interface{}) uint64 {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+
+	if id, ok := T.ids[v]; ok {
+		return id
+	}
+	if T.ids == nil {
+		T.ids = make(map[interface{}]uint64)
+	}
+	if len(T.order) >= tlsRegistryMax {
+		delete(T.ids, T.order[0])
+		T.order = T.order[1:]
+	}
+	T.next++
+	T.ids[v] = T.next
+	T.order = append(T.order, v)
+	return T.next
+}
+
+// tlsConfigKey TLS 配置的 key，影响连接身份的字段相同则 key 相同。
+// 证书使用内容，根证书池使用登记的编号，配置有回调函数时使用配置本身登记的编号。
+func (T *tlsRegistry) key(config *tls.Config) string {
+	h := sha256.New()
+	num := func(n uint64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		h.Write(b[:])
+	}
+	bool2num := func(b bool) uint64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	num(bool2num(config.InsecureSkipVerify))
+	num(uint64(config.MinVersion))
+	num(uint64(config.MaxVersion))
+	num(uint64(config.Renegotiation))
+	num(uint64(len(config.CipherSuites)))
+	for _, cs := range config.CipherSuites {
+		num(uint64(cs))
+	}
+	num(uint64(len(config.CurvePreferences)))
+	for _, cp := range config.CurvePreferences {
+		num(uint64(cp))
+	}
+	num(uint64(len(config.Certificates)))
+	for _, cert := range config.Certificates {
+		num(uint64(len(cert.Certificate)))
+		for _, der := range cert.Certificate {
+			sum := sha256.Sum256(der)
+			h.Write(sum[:])
+		}
+	}
+	if config.RootCAs != nil {
+		num(T.id(config.RootCAs))
+	} else {
+		num(0)
+	}
+	if config.GetClientCertificate != nil || config.VerifyPeerCertificate != nil || config.VerifyConnection != nil {
+		num(T.id(config))
+	} else {
+		num(0)
+	}
+	return strings.Join(config.NextProtos, "/") + "," + hex.EncodeToString(h.Sum(nil)[:8])
+}
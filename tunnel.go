@@ -0,0 +1,487 @@
+package vconnpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TunnelNetwork 反向隧道的连接类型，DialContext(TunnelNetwork, "代理ID")
+const TunnelNetwork = "agent"
+
+const (
+	tunnelControl     = "CTRL" // 控制连接
+	tunnelData        = "DATA" // 数据连接
+	tunnelOpen        = "OPEN" // 通知代理端创建连接
+	tunnelMaxLine     = 1024   // 握手行的最大长度
+	tunnelIdeConn     = 64     // 默认空闲连接数
+	tunnelMaxOpen     = 64     // 默认每个代理端等待创建的连接数上限
+	tunnelOpenTimeout = 10 * time.Second
+	tunnelRetryDelay  = time.Second
+)
+
+var (
+	errorTunnelHandshake = errors.New("vconnpool: invalid tunnel handshake")
+	errorTunnelControl   = errors.New("vconnpool: the tunnel agent control connection already exists")
+	ErrTunnelOffline     = errors.New("vconnpool: the tunnel agent is not online")
+	ErrTunnelBusy        = errors.New("vconnpool: too many pending tunnel connections")
+)
+
+// readLine 逐字节读取一行，避免读取到握手之后的数据
+func readLine(conn net.Conn) (string, error) {
+	var (
+		line []byte
+		b    = make([]byte, 1)
+	)
+	for len(line) < tunnelMaxLine {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errorTunnelHandshake
+}
+
+func writeLine(conn net.Conn, cmd, arg string) error {
+	_, err := conn.Write([]byte(cmd + " " + arg + "\n"))
+	return err
+}
+
+func parseLine(line string) (cmd, arg string, err error) {
+	s := strings.SplitN(line, " ", 2)
+	if len(s) != 2 || s[1] == "" {
+		return "", "", errorTunnelHandshake
+	}
+	return s[0], s[1], nil
+}
+
+// TunnelAgent 反向隧道代理端，主动连接到服务端（TunnelBroker）并注册身份，
+// 维持多条连接等待服务端使用。适用于 NAT 之后的设备。
+type TunnelAgent struct {
+	Dialer     Dialer                    // 拨号，为nil 使用 net.Dialer
+	Network    string                    // 服务端连接类型
+	Address    string                    // 服务端地址
+	ID         string                    // 身份标识
+	Conns      int                       // 维持的连接数，0为1
+	Handler    func(conn net.Conn)       // 处理服务端使用的连接，函数返回后关闭连接
+	RetryDelay time.Duration             // 重连延迟，0为1秒
+	Handshake  func(conn net.Conn) error // 发送身份之后调用（如发送凭证），返回错误关闭连接
+
+	cancel context.CancelFunc // 取消 Run
+	closed bool               // 已经关闭
+	mu     sync.Mutex
+	wg     sync.WaitGroup // Run 和它启动的协程
+}
+
+func (T *TunnelAgent) retryDelay() time.Duration {
+	if T.RetryDelay != 0 {
+		return T.RetryDelay
+	}
+	return tunnelRetryDelay
+}
+
+func (T *TunnelAgent) dial(ctx context.Context, cmd string) (net.Conn, error) {
+	dialer := T.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	conn, err := dialer.DialContext(ctx, T.Network, T.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeLine(conn, cmd, T.ID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if T.Handshake != nil {
+		if err = T.Handshake(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// serve 创建一条数据连接，并交给 Handler 处理
+func (T *TunnelAgent) serve(ctx context.Context) (time.Duration, error) {
+	conn, err := T.dial(ctx, tunnelData)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	if T.Handler != nil {
+		T.Handler(conn)
+	} else {
+		conn.Read(make([]byte, 1))
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+// keep 维持一条数据连接，连接关闭后重新创建
+func (T *TunnelAgent) keep(ctx context.Context) {
+	for {
+		d, err := T.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil || d < T.retryDelay() {
+			// 拨号失败或连接很快被关闭（如服务端池已满），延迟重连
+			select {
+			case <-time.After(T.retryDelay()):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Run 运行代理端，直到 ctx 取消
+//
+//	ctx context.Context 上下文
+//	error               错误
+func (T *TunnelAgent) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	T.mu.Lock()
+	if T.closed {
+		T.mu.Unlock()
+		return net.ErrClosed
+	}
+	T.cancel = cancel
+	T.wg.Add(1)
+	T.mu.Unlock()
+	defer T.wg.Done()
+
+	n := T.Conns
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		T.spawn(func() { T.keep(ctx) })
+	}
+
+	for {
+		// 控制连接断开后重连
+		T.control(ctx)
+		select {
+		case <-time.After(T.retryDelay()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close 停止 Run，并等待所有连接的 Handler 返回
+//
+//	error           错误
+func (T *TunnelAgent) Close() error {
+	T.mu.Lock()
+	T.closed = true
+	if T.cancel != nil {
+		T.cancel()
+	}
+	T.mu.Unlock()
+	T.wg.Wait()
+	return nil
+}
+
+// spawn 启动协程，Close 等待它返回。只在 Run 中调用，Run 返回之前计数不为0
+func (T *TunnelAgent) spawn(f func()) {
+	T.wg.Add(1)
+	go func() {
+		defer T.wg.Done()
+		f()
+	}()
+}
+
+// control 控制连接，接收服务端创建连接的通知
+func (T *TunnelAgent) control(ctx context.Context) error {
+	conn, err := T.dial(ctx, tunnelControl)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	defer conn.Close()
+
+	for {
+		line, err := readLine(conn)
+		if err != nil {
+			return err
+		}
+		cmd, arg, err := parseLine(line)
+		if err != nil || cmd != tunnelOpen {
+			return errorTunnelHandshake
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return errorTunnelHandshake
+		}
+		for i := 0; i < n; i++ {
+			T.spawn(func() { T.serve(ctx) })
+		}
+	}
+}
+
+// TunnelBroker 反向隧道服务端，接受代理端（TunnelAgent）的连接并存放在池中。
+// 它实现了 Dialer 接口，DialContext(TunnelNetwork, "代理ID") 从池中读取代理端的连接，
+// 池中没有空闲连接时，通知代理端创建。
+// 注意：Pool 的 Dialer 和 ResolveAddr 会被设置，IdeConn 需要大于代理端维持的连接数。
+// Authenticate 为nil 时不验证代理端，同一个ID 已有控制连接，新的控制连接被拒绝；
+// 设置 Authenticate 后，通过验证的控制连接替换旧的控制连接。
+type TunnelBroker struct {
+	Pool             *ConnPool                            // 连接池，为nil 自动创建
+	HandshakeTimeout time.Duration                        // 握手超时，0为不超时
+	OpenTimeout      time.Duration                        // 等待代理端创建连接超时，0为10秒
+	MaxOpen          int                                  // 每个代理端等待创建的连接数上限，超出返回 ErrTunnelBusy，0为64
+	Authenticate     func(id string, conn net.Conn) error // 验证代理端的控制连接和数据连接，返回错误关闭连接。为nil 不验证
+
+	controls map[string]net.Conn        // 控制连接
+	waiters  map[string][]chan net.Conn // 等待代理端创建的连接
+	mu       sync.Mutex
+	once     sync.Once
+}
+
+func (T *TunnelBroker) init() {
+	T.once.Do(func() {
+		T.controls = make(map[string]net.Conn)
+		T.waiters = make(map[string][]chan net.Conn)
+		if T.Pool == nil {
+			T.Pool = &ConnPool{IdeConn: tunnelIdeConn}
+		}
+		T.Pool.Dialer = &tunnelDialer{broker: T}
+		T.Pool.ResolveAddr = func(network, address string) (net.Addr, error) {
+			if network == TunnelNetwork {
+				return NewAddr(network, address), nil
+			}
+			return ResolveAddr(network, address)
+		}
+	})
+}
+
+// Serve 接受代理端的连接，直到监听器关闭
+//
+//	l net.Listener  监听器
+//	error           错误
+func (T *TunnelBroker) Serve(l net.Listener) error {
+	T.init()
+	pl := &PoolListener{
+		Listener:         l,
+		Pool:             T.Pool,
+		Identify:         T.identify,
+		HandshakeTimeout: T.HandshakeTimeout,
+	}
+	return pl.Serve()
+}
+
+func (T *TunnelBroker) identify(conn net.Conn) (net.Addr, error) {
+	line, err := readLine(conn)
+	if err != nil {
+		return nil, err
+	}
+	cmd, id, err := parseLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if cmd != tunnelControl && cmd != tunnelData {
+		return nil, errorTunnelHandshake
+	}
+	if T.Authenticate != nil {
+		if err := T.Authenticate(id, conn); err != nil {
+			return nil, err
+		}
+	}
+	switch cmd {
+	case tunnelControl:
+		// 没有验证，不能替换已有的控制连接
+		if err := T.addControl(id, conn, T.Authenticate != nil); err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return nil, nil
+	case tunnelData:
+		if T.deliver(id, conn) {
+			conn.SetDeadline(time.Time{})
+			return nil, nil
+		}
+		return NewAddr(TunnelNetwork, id), nil
+	}
+	return nil, errorTunnelHandshake
+}
+
+// addControl 登记控制连接，replace 为 true 替换已有的控制连接，否则返回错误
+func (T *TunnelBroker) addControl(id string, conn net.Conn, replace bool) error {
+	T.mu.Lock()
+	if old, ok := T.controls[id]; ok {
+		if !replace {
+			T.mu.Unlock()
+			return errorTunnelControl
+		}
+		old.Close()
+	}
+	T.controls[id] = conn
+	T.mu.Unlock()
+
+	go func() {
+		// 代理端不发送数据，读取失败表示连接关闭
+		conn.Read(make([]byte, 1))
+		conn.Close()
+
+		T.mu.Lock()
+		defer T.mu.Unlock()
+		if T.controls[id] == conn {
+			delete(T.controls, id)
+		}
+	}()
+	return nil
+}
+
+// deliver 把新连接交给等待者
+func (T *TunnelBroker) deliver(id string, conn net.Conn) bool {
+	T.mu.Lock()
+	defer T.mu.Unlock()
+
+	ws := T.waiters[id]
+	if len(ws) == 0 {
+		return false
+	}
+	ws[0] <- conn
+	if len(ws) == 1 {
+		delete(T.waiters, id)
+	} else {
+		T.waiters[id] = ws[1:]
+	}
+	return true
+}
+
+// wait 通知代理端创建连接，并等待连接
+func (T *TunnelBroker) wait(ctx context.Context, id string) (net.Conn, error) {
+	ch := make(chan net.Conn, 1)
+
+	T.mu.Lock()
+	ctrl, ok := T.controls[id]
+	if !ok {
+		T.mu.Unlock()
+		return nil, ErrTunnelOffline
+	}
+	maxOpen := T.MaxOpen
+	if maxOpen == 0 {
+		maxOpen = tunnelMaxOpen
+	}
+	if len(T.waiters[id]) >= maxOpen {
+		T.mu.Unlock()
+		return nil, ErrTunnelBusy
+	}
+	T.waiters[id] = append(T.waiters[id], ch)
+	T.mu.Unlock()
+
+	// 在锁外写入，代理端阻塞不影响其它操作
+	timeout := T.OpenTimeout
+	if timeout == 0 {
+		timeout = tunnelOpenTimeout
+	}
+	ctrl.SetWriteDeadline(time.Now().Add(timeout))
+	err := writeLine(ctrl, tunnelOpen, "1")
+	if err == nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case conn := <-ch:
+			return conn, nil
+		case <-timer.C:
+			err = fmt.Errorf("vconnpool: timeout waiting for tunnel agent %s", id)
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	// 删除等待，如果连接已经送达，则放入池中
+	T.mu.Lock()
+	ws := T.waiters[id]
+	for i, w := range ws {
+		if w == ch {
+			ws = append(ws[:i:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(T.waiters, id)
+	} else {
+		T.waiters[id] = ws
+	}
+	T.mu.Unlock()
+
+	select {
+	case conn := <-ch:
+		if T.Pool.Put(conn, NewAddr(TunnelNetwork, id)) != nil {
+			conn.Close()
+		}
+	default:
+	}
+	return nil, err
+}
+
+// Dial 见 DialContext
+//
+//	network string      连接类型，TunnelNetwork
+//	address string      代理ID
+//	net.Conn            连接
+//	error               错误
+func (T *TunnelBroker) Dial(network, address string) (net.Conn, error) {
+	return T.DialContext(context.Background(), network, address)
+}
+
+// DialContext 读取代理端的连接，调用 Close 关闭后，自动收回。
+//
+//	ctx context.Context 上下文
+//	network string      连接类型，TunnelNetwork
+//	address string      代理ID
+//	net.Conn            连接
+//	error               错误
+func (T *TunnelBroker) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	T.init()
+	return T.Pool.DialContext(ctx, network, address)
+}
+
+// tunnelDialer 池中没有空闲连接时，由池调用创建连接
+type tunnelDialer struct {
+	broker *TunnelBroker
+}
+
+func (T *tunnelDialer) Dial(network, address string) (net.Conn, error) {
+	return T.DialContext(context.Background(), network, address)
+}
+
+func (T *tunnelDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != TunnelNetwork {
+		return nil, fmt.Errorf("the network type %s not support", network)
+	}
+	return T.broker.wait(ctx, address)
+}
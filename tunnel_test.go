@@ -0,0 +1,286 @@
+package vconnpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/issue9/assert/v2"
+)
+
+func tunnelEcho(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// 服务端通过代理端维持的连接通信，关闭后回收到池中
+func Test_Tunnel_1(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+
+	broker := &TunnelBroker{Pool: &ConnPool{IdeConn: 5}}
+	defer broker.Pool.Close()
+	go broker.Serve(l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := &TunnelAgent{
+		Network:    "tcp",
+		Address:    l.Addr().String(),
+		ID:         "dev1",
+		Conns:      1,
+		Handler:    tunnelEcho,
+		RetryDelay: 10 * time.Millisecond,
+	}
+	go agent.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = broker.Dial(TunnelNetwork, "dev2")
+	as.ErrorIs(err, ErrTunnelOffline)
+
+	conn, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	as.True(conn.(Conn).IsReuseConn())
+
+	_, err = conn.Write([]byte("ping"))
+	as.NotError(err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(conn, b)
+	as.NotError(err).Equal(string(b), "ping")
+	conn.Close()
+
+	as.Equal(broker.Pool.ConnNumIde(TunnelNetwork, "dev1"), 1)
+}
+
+// 池中没有空闲连接，通知代理端创建
+func Test_Tunnel_2(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+
+	broker := &TunnelBroker{Pool: &ConnPool{IdeConn: 5}, OpenTimeout: time.Second}
+	defer broker.Pool.Close()
+	go broker.Serve(l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := &TunnelAgent{
+		Network:    "tcp",
+		Address:    l.Addr().String(),
+		ID:         "dev1",
+		Conns:      1,
+		Handler:    tunnelEcho,
+		RetryDelay: 10 * time.Millisecond,
+	}
+	go agent.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	conn1, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	defer conn1.Close()
+	as.True(conn1.(Conn).IsReuseConn())
+
+	conn2, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	defer conn2.Close()
+	as.False(conn2.(Conn).IsReuseConn())
+
+	_, err = conn2.Write([]byte("pong"))
+	as.NotError(err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(conn2, b)
+	as.NotError(err).Equal(string(b), "pong")
+	as.Equal(broker.Pool.ConnNum(), 2)
+}
+
+// Close 等待 OPEN 通知创建的连接处理完成
+func Test_Tunnel_Close(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+
+	broker := &TunnelBroker{Pool: &ConnPool{IdeConn: 5}, OpenTimeout: time.Second}
+	defer broker.Pool.Close()
+	go broker.Serve(l)
+
+	var active int32
+	agent := &TunnelAgent{
+		Network: "tcp",
+		Address: l.Addr().String(),
+		ID:      "dev1",
+		Conns:   1,
+		Handler: func(conn net.Conn) {
+			atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			tunnelEcho(conn)
+		},
+		RetryDelay: 10 * time.Millisecond,
+	}
+	go agent.Run(context.Background())
+	time.Sleep(50 * time.Millisecond)
+
+	conn1, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	defer conn1.Close()
+	conn2, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	defer conn2.Close()
+	as.Equal(atomic.LoadInt32(&active), int32(2))
+
+	as.NotError(agent.Close())
+	as.Equal(atomic.LoadInt32(&active), int32(0))
+	as.ErrorIs(agent.Run(context.Background()), net.ErrClosed)
+}
+
+// 代理端不读取控制连接，不阻塞其它操作
+func Test_Tunnel_stuckControl(t *testing.T) {
+	as := assert.New(t, true)
+
+	broker := &TunnelBroker{Pool: &ConnPool{IdeConn: 5}, OpenTimeout: 200 * time.Millisecond}
+	defer broker.Pool.Close()
+	broker.init()
+
+	ctrl, agent := net.Pipe()
+	defer agent.Close()
+	as.NotError(broker.addControl("dev1", ctrl, false))
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := broker.wait(context.Background(), "dev1")
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		broker.deliver("dev2", nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("broker is blocked by the control connection")
+	}
+	as.Error(<-errc)
+}
+
+// 验证代理端，没有通过验证的连接被关闭，不能替换已有的控制连接
+func Test_Tunnel_Authenticate(t *testing.T) {
+	as := assert.New(t, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	as.NotError(err)
+	defer l.Close()
+
+	broker := &TunnelBroker{
+		Pool:             &ConnPool{IdeConn: 5},
+		HandshakeTimeout: time.Second,
+		OpenTimeout:      time.Second,
+		Authenticate: func(id string, conn net.Conn) error {
+			line, err := readLine(conn)
+			if err != nil {
+				return err
+			}
+			if line != "TOKEN "+id+"-secret" {
+				return errors.New("invalid token")
+			}
+			return nil
+		},
+	}
+	defer broker.Pool.Close()
+	go broker.Serve(l)
+
+	agent := &TunnelAgent{
+		Network: "tcp",
+		Address: l.Addr().String(),
+		ID:      "dev1",
+		Conns:   1,
+		Handler: tunnelEcho,
+		Handshake: func(conn net.Conn) error {
+			return writeLine(conn, "TOKEN", "dev1-secret")
+		},
+		RetryDelay: 10 * time.Millisecond,
+	}
+	go agent.Run(context.Background())
+	defer agent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	for _, cmd := range []string{tunnelControl, tunnelData} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		as.NotError(err)
+		_, err = conn.Write([]byte(cmd + " dev1\nTOKEN bad\n"))
+		as.NotError(err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		as.ErrorIs(err, io.EOF)
+		conn.Close()
+	}
+
+	// 第二条连接通过控制连接通知代理端创建
+	conn1, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	defer conn1.Close()
+	conn2, err := broker.Dial(TunnelNetwork, "dev1")
+	as.NotError(err)
+	defer conn2.Close()
+}
+
+// 没有验证，已有的控制连接不被替换
+func Test_Tunnel_addControl(t *testing.T) {
+	as := assert.New(t, true)
+
+	broker := &TunnelBroker{Pool: &ConnPool{IdeConn: 5}}
+	defer broker.Pool.Close()
+	broker.init()
+
+	ctrl1, agent1 := net.Pipe()
+	defer agent1.Close()
+	ctrl2, agent2 := net.Pipe()
+	defer agent2.Close()
+
+	as.NotError(broker.addControl("dev1", ctrl1, false))
+	as.Error(broker.addControl("dev1", ctrl2, false))
+	as.Equal(broker.controls["dev1"], ctrl1)
+
+	// 替换后关闭旧的控制连接
+	as.NotError(broker.addControl("dev1", ctrl2, true))
+	as.Equal(broker.controls["dev1"], ctrl2)
+	_, err := agent1.Read(make([]byte, 1))
+	as.Error(err)
+}
+
+// 等待创建的连接超出上限
+func Test_Tunnel_MaxOpen(t *testing.T) {
+	as := assert.New(t, true)
+
+	broker := &TunnelBroker{Pool: &ConnPool{IdeConn: 5}, OpenTimeout: 200 * time.Millisecond, MaxOpen: 1}
+	defer broker.Pool.Close()
+	broker.init()
+
+	ctrl, agent := net.Pipe()
+	defer agent.Close()
+	as.NotError(broker.addControl("dev1", ctrl, false))
+	go io.Copy(io.Discard, agent)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := broker.wait(context.Background(), "dev1")
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	_, err := broker.wait(context.Background(), "dev1")
+	as.ErrorIs(err, ErrTunnelBusy)
+	err = <-errc
+	as.Error(err).False(errors.Is(err, ErrTunnelBusy))
+}